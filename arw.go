@@ -6,10 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"reflect"
 	"strings"
-	"unsafe"
 )
 
 //CIPA DC-008-2012 Table 1
@@ -204,6 +201,10 @@ const (
 	DateTime                  IFDtag = 306
 	Whitepoint                IFDtag = 318
 	PrimaryChromaticities     IFDtag = 319
+	TileWidth                 IFDtag = 322
+	TileLength                IFDtag = 323
+	TileOffsets               IFDtag = 324
+	TileByteCounts            IFDtag = 325
 	SubIFDs                   IFDtag = 330
 
 	JPEGInterchangeFormat       IFDtag = 513
@@ -271,12 +272,14 @@ const (
 	DistortionCorrParams          IFDtag = 0x7982
 
 	ExifTag             IFDtag = 34665
+	InterColorProfile   IFDtag = 34675
 	GPSTag              IFDtag = 34853
 	InteroperabilityTag IFDtag = 40965
 	PrintImageMatching  IFDtag = 50341
 	DefaultCropOrigin   IFDtag = 50719
 	DefaultCropSize     IFDtag = 50720
 	DNGPrivateData      IFDtag = 50740
+	ActiveArea          IFDtag = 50829
 
 	ExposureTime             IFDtag = 33434
 	FNumber                  IFDtag = 33437
@@ -339,6 +342,7 @@ const (
 	DeviceSettingDescription IFDtag = 41995
 	SubjectDistanceRange     IFDtag = 41996
 	ImageUniqueID            IFDtag = 42016
+	BodySerialNumber         IFDtag = 42033
 	LensSpecification        IFDtag = 42034
 	LensModel                IFDtag = 42036
 	Gamma                    IFDtag = 42240
@@ -346,14 +350,19 @@ const (
 	CFAPattern2              IFDtag = 0x828e
 )
 
-//go:generate stringer -type=sonyRawFile
-type sonyRawFile uint16
+//RawFormat is how the raw data is stored, the values match the SonyRawFileType tag.
+//go:generate stringer -type=RawFormat
+type RawFormat uint16
 
 const (
-	raw14 sonyRawFile = iota
-	raw12
-	craw
-	crawLossless
+	//Raw14 is uncompressed 14 bit data.
+	Raw14 RawFormat = iota
+	//Raw12 is uncompressed data packed as 12 bits.
+	Raw12
+	//CRAW is Sony's lossy compression, 7 bit deltas in blocks of 16 photosites expanded through a curve.
+	CRAW
+	//CRAWLossless is lossless JPEG compressed in tiles.
+	CRAWLossless
 )
 
 //IFD datatype, most datatypes translate in to C datatypes.
@@ -390,97 +399,183 @@ func (i IFDtype) Len() int {
 	}
 }
 
-//Anyone who thinks I'm switching byte order mid program is sorely mistaken.
-var b binary.ByteOrder = binary.LittleEndian
+//Order returns the byte order the document was written in.
+func (h TIFFHeader) Order() binary.ByteOrder {
+	if h.ByteOrder == 0x4d4d {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+var (
+	//ErrTruncated is returned when a structure runs past the end of the file.
+	ErrTruncated = errors.New("unexpected end of file")
+	//ErrBadIFD is returned when an IFD or one of its fields is malformed.
+	ErrBadIFD = errors.New("malformed IFD")
+	//ErrValueOutOfRange is returned when an offset, count or size is larger than the file can hold.
+	ErrValueOutOfRange = errors.New("value out of range")
+)
+
+const (
+	//maxIFDEntries limits the number of fields in a single IFD, real files have a few hundred at most.
+	maxIFDEntries = 4096
+	//maxValueSize limits the size of a single field value, the largest we have seen are MakerNotes of a few hundred KiB.
+	maxValueSize = 16 << 20
+)
 
 //Parses a TIFF header to determine first IFD and endianness.
 func ParseHeader(r io.ReadSeeker) (TIFFHeader, error) {
+	var order binary.ByteOrder
+
 	endian := make([]byte, 2)
-	r.Read(endian)
+	if _, err := io.ReadFull(r, endian); err != nil {
+		return TIFFHeader{}, ErrTruncated
+	}
 	switch string(endian) {
 	case "II":
-		b = binary.LittleEndian
+		order = binary.LittleEndian
 	case "MM":
-		b = binary.BigEndian
+		order = binary.BigEndian
 	default:
 		return TIFFHeader{}, errors.New("failed to determine endianness: " + fmt.Sprint(endian))
 	}
-	r.Seek(-2, 1)
+	if _, err := r.Seek(-2, 1); err != nil {
+		return TIFFHeader{}, err
+	}
 
 	var header TIFFHeader
-	binary.Read(r, b, &header)
+	if err := binary.Read(r, order, &header); err != nil {
+		return TIFFHeader{}, ErrTruncated
+	}
 	if header.FortyTwo != 42 {
 		return header, errors.New("found an endianness marker but no fixed 42, offset might be unreliable")
 	}
 	return header, nil
 }
 
-//ExtractMetadata will return the first IFD from a TIFF document.
+//ExtractMetadata will return the IFD at offset from a TIFF document, the byte order is read from its header.
+//Without a header, as in a decrypted SR2 block, the IFD is read as little endian like Sony writes it.
 func ExtractMetaData(r io.ReadSeeker, offset int64, whence int) (meta EXIFIFD, err error) {
-	r.Seek(offset, whence)
-	binary.Read(r, b, &meta.Count)
+	start, err := r.Seek(offset, whence)
+	if err != nil {
+		return meta, ErrValueOutOfRange
+	}
+	if _, err := r.Seek(0, 0); err != nil {
+		return meta, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header, err := ParseHeader(r); err == nil {
+		order = header.Order()
+	}
+	return ExtractMetaDataWithOrder(r, order, start, 0)
+}
+
+//ExtractMetaDataWithOrder returns the IFD at offset from a TIFF document without reading its header again.
+//The byte order is that of the document, as returned by TIFFHeader.Order.
+//Fields of an unknown type are kept but their value is left empty.
+func ExtractMetaDataWithOrder(r io.ReadSeeker, order binary.ByteOrder, offset int64, whence int) (meta EXIFIFD, err error) {
+	start, err := r.Seek(offset, whence)
+	if err != nil {
+		return meta, ErrValueOutOfRange
+	}
+	size, err := r.Seek(0, 2)
+	if err != nil {
+		return meta, err
+	}
+	if start >= size {
+		return meta, ErrValueOutOfRange
+	}
+	if _, err := r.Seek(start, 0); err != nil {
+		return meta, err
+	}
+
+	if err := binary.Read(r, order, &meta.Count); err != nil {
+		return meta, ErrTruncated
+	}
+	if meta.Count > maxIFDEntries {
+		return meta, ErrBadIFD
+	}
+	if start+2+int64(meta.Count)*12+4 > size {
+		return meta, ErrTruncated
+	}
 	meta.FIA = make([]IFDFIA, int(meta.Count))
-	binary.Read(r, b, &meta.FIA)
-	binary.Read(r, b, &meta.Offset)
+	if err := binary.Read(r, order, &meta.FIA); err != nil {
+		return meta, ErrTruncated
+	}
+	if err := binary.Read(r, order, &meta.Offset); err != nil {
+		return meta, ErrTruncated
+	}
 
 	meta.FIAvals = make([]FIAval, len(meta.FIA))
 	for n, interop := range meta.FIA {
 		meta.FIAvals[n].IFDtype = interop.Type
 
+		length := int64(interop.Type.Len()) * int64(interop.Count)
+		if length < 0 {
+			continue
+		}
+
 		//Offset field is actually the value
-		if uint32(interop.Type.Len())*interop.Count <= 4 {
+		if length <= 4 {
+			var field [4]byte
+			order.PutUint32(field[:], interop.Offset)
+
 			switch interop.Type {
 			case UNDEFINED, ASCII, BYTE:
 				values := make([]byte, interop.Count)
-				for i := range values {
-					values[i] = byte(((interop.Offset << uint32(8*i)) & 0xff000000) >> 24)
-				}
+				copy(values, field[:])
 				meta.FIAvals[n].ascii = &values
 			case SHORT:
 				values := make([]uint16, interop.Count)
 				for i := range values {
-					values[i] = uint16(((interop.Offset << uint32(16*i)) & 0xffff0000) >> 16)
+					values[i] = order.Uint16(field[2*i:])
 				}
 				meta.FIAvals[n].short = &values
 			case SSHORT:
 				values := make([]int16, interop.Count)
 				for i := range values {
-					values[i] = int16(((interop.Offset << uint32(16*i)) & 0xffff0000) >> 16)
+					values[i] = int16(order.Uint16(field[2*i:]))
 				}
 				meta.FIAvals[n].sshort = &values
 			case LONG:
-				values := []uint32{interop.Count}
+				values := []uint32{interop.Offset}
 				meta.FIAvals[n].long = &values
 			case SLONG:
-				values := []int32{int32(interop.Count)}
+				values := []int32{int32(interop.Offset)}
 				meta.FIAvals[n].slong = &values
 			}
 		} else {
-			r.Seek(int64(interop.Offset), 0)
+			if length > maxValueSize || int64(interop.Offset)+length > size {
+				return meta, ErrValueOutOfRange
+			}
+			if _, err := r.Seek(int64(interop.Offset), 0); err != nil {
+				return meta, err
+			}
+
 			switch interop.Type {
 			case UNDEFINED, ASCII, BYTE:
 				values := make([]byte, interop.Count)
-				binary.Read(r, b, &values)
+				err = binary.Read(r, order, &values)
 				meta.FIAvals[n].ascii = &values
 			case SHORT:
 				values := make([]uint16, interop.Count)
-				binary.Read(r, b, &values)
+				err = binary.Read(r, order, &values)
 				meta.FIAvals[n].short = &values
 			case SSHORT:
 				values := make([]int16, interop.Count)
-				binary.Read(r, b, &values)
+				err = binary.Read(r, order, &values)
 				meta.FIAvals[n].sshort = &values
 			case LONG:
 				values := make([]uint32, interop.Count)
-				binary.Read(r, b, &values)
+				err = binary.Read(r, order, &values)
 				meta.FIAvals[n].long = &values
 			case SLONG:
 				values := make([]int32, interop.Count)
-				binary.Read(r, b, &values)
+				err = binary.Read(r, order, &values)
 				meta.FIAvals[n].slong = &values
 			case RATIONAL:
 				values := make([]uint32, interop.Count*2)
-				binary.Read(r, b, &values)
+				err = binary.Read(r, order, &values)
 				floats := make([]float32, interop.Count)
 				for i := range floats {
 					floats[i] = float32(values[i*2]) / float32(values[(i*2)+1])
@@ -488,44 +583,55 @@ func ExtractMetaData(r io.ReadSeeker, offset int64, whence int) (meta EXIFIFD, e
 				meta.FIAvals[n].rat = &floats
 			case SRATIONAL:
 				values := make([]int32, interop.Count*2)
-				binary.Read(r, b, &values)
+				err = binary.Read(r, order, &values)
 				floats := make([]float32, interop.Count)
 				for i := range floats {
 					floats[i] = float32(values[i*2]) / float32(values[(i*2)+1])
 				}
 				meta.FIAvals[n].rat = &floats
 			}
+			if err != nil {
+				return meta, ErrTruncated
+			}
 		}
 	}
 
-	return
+	return meta, nil
 }
 
 //TODO(sjon): We probably want to generate this pad ourselves if we ever discover versions of ARW which use a real key.
 //All current variants just have a default placeholder in the key field.
 //TODO(sjon): BUG this function can't be called multiple times since the pad is consumed. for now the pad is put in the function body.
 
-func DecryptSR2(r io.ReadSeeker, offset uint32, length uint32) []byte {
+func DecryptSR2(r io.ReadSeeker, offset uint32, length uint32) ([]byte, error) {
 	var pad = []uint32{0xae567acf, 0x3758b80d, 0x7c2906a5, 0x1a30e50c, 0xa4fff8d4, 0x5ad0ba02, 0xb0adfde3, 0x80c0bf1c, 0x28a40a6e, 0xb5210a3c, 0x3013ee1b, 0x6ac26b41, 0x306ec9eb, 0xbfc7c3fa, 0x01fa4ee0, 0xaa0b5077, 0x63280f17, 0x2b98271b, 0xc4a483ee, 0x0327efd8, 0x4f1919f3, 0x507e9187, 0x167b353b, 0xa7b2fcbe, 0xb2c45890, 0xef99db72, 0x497fdb56, 0x91564e98, 0xf777078d, 0xfd9e2bd5, 0x7c11b8b7, 0xd890cb9a, 0x16cd7e75, 0x4b1cc09f, 0xd4b88d85, 0x2719170a, 0x85ebe6e1, 0xd80aae2b, 0xa2a6d6c8, 0xfe277243, 0x4e9a6052, 0x4d5ab8d1, 0xd9796c35, 0x66fb9425, 0x2fc719ce, 0x574259e8, 0xed7debf6, 0x62729b9b, 0x8475e571, 0x6b6084e7, 0xd2101c0e, 0x12243ef8, 0xaccaf2ff, 0xf388743f, 0xfdb4dde3, 0xc259958e, 0xa3fc5e38, 0x63a2c363, 0xbd9006b7, 0x43f7adda, 0x3dd8b01e, 0x41aadc72, 0x01916c53, 0x04bae250, 0x7892b89b, 0x8b207c44, 0xf206a891, 0x1e353d29, 0x14292114, 0x2b2b82da, 0xcd5f120b, 0x6a3c7ee7, 0xb2ed663e, 0x822ef87b, 0xff64e96a, 0xd0250c39, 0x9a121fa9, 0xa516e885, 0xcbecec87, 0xea66c879, 0xa3fce75d, 0x9fe040f8, 0xd12016b4, 0xeb0c1103, 0xe5b8e3d3, 0xe8d8a3f6, 0x6930ebcf, 0x06a865eb, 0x18111138, 0xdde18c3b, 0xe342f4ef, 0xb793d2a1, 0xf7a7caaf, 0xd4e4bc34, 0x29ca7d80, 0xc6eedc2a, 0xbcdb6e5f, 0x2514c03c, 0x2a2326be, 0xc7f5392c, 0x2cf191c2, 0xc4c3f321, 0x0ca46ff9, 0x066c941b, 0x40aafc77, 0x855fcf74, 0x981c261d, 0x0667b6de, 0xb16db5d5, 0x0771f254, 0x53e22691, 0x022c8814, 0xc41f2789, 0x0abaf480, 0x2ffb0330, 0x112cf928, 0xd7c94972, 0x362c1b50, 0xf0659484, 0x4f00c4f1, 0x4f58bbed, 0xf258be43, 0x7f7b5ed2, 0x7ab1f464, 0x6046ca7f, 0x11d3954e, 0x3e7a285b, 0x00000000}
+	size, err := r.Seek(0, 2)
+	if err != nil {
+		return nil, err
+	}
+	if int64(offset)+int64(length) > size {
+		return nil, ErrValueOutOfRange
+	}
+
 	buf := make([]byte, length)
-	r.Seek(int64(offset), 0)
-	r.Read(buf)
-	header := *(*reflect.SliceHeader)(unsafe.Pointer(&buf))
-	header.Len /= 4
-	header.Cap /= 4
-	data := *(*[]uint32)(unsafe.Pointer(&header))
+	if _, err := r.Seek(int64(offset), 0); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, ErrTruncated
+	}
 
 	p := 128
-	for i := range data {
+	for i := 0; i+4 <= len(buf); i += 4 {
 		pad[(p-1)&127] = pad[p&127] ^ pad[(p+64)&127]
-		data[i] ^= pad[(p-1)&127]
+		binary.LittleEndian.PutUint32(buf[i:], binary.LittleEndian.Uint32(buf[i:])^pad[(p-1)&127])
 		p++
 	}
 
-	return buf
+	return buf, nil
 }
 
-//ExtractThumbnail extracts an embedded JPEG thumbnail.
+//ExtractThumbnail extracts an embedded JPEG thumbnail, Previews lists where they are stored.
 func ExtractThumbnail(r io.ReaderAt, offset uint32, length uint32) ([]byte, error) {
 	jpegData := make([]byte, length)
 	_, err := r.ReadAt(jpegData, int64(offset))
@@ -552,20 +658,23 @@ func (p crawPixelBlock) String() string {
 	return fmt.Sprintf("Max: %v\tMin: %v\nMaxIdx: %v\tMinIdx: %v\nDeltas: %v\n", p.max, p.min, p.maxidx, p.minidx, p.pix)
 }
 
-func (p crawPixelBlock) Decompress() [pixelBlockSize]pixel {
+func (p crawPixelBlock) Decompress() ([pixelBlockSize]pixel, error) {
 	var pix [pixelBlockSize]pixel
-	factor := (p.max - p.min) / 128
 	var ordinary int
 
-	if p.max < p.min {
-		log.Println(p)
-		panic("Expected max to be larger than min")
+	//The indices are four bits in a stored block, anything larger wasn't read from one.
+	if p.maxidx >= pixelBlockSize || p.minidx >= pixelBlockSize {
+		return pix, ErrCorrupt
 	}
-	if p.maxidx == p.minidx {
-		log.Println(p)
-		panic("Expected non overlapping min and max position")
+
+	//The deltas are 7 bit, blocks with a larger range store them with less precision.
+	//A maximum below the minimum is decoded like dcraw does, without a shift.
+	var shift uint
+	for shift < 4 && 0x80<<shift <= int(p.max)-int(p.min) {
+		shift++
 	}
 
+	//When both indices coincide dcraw still decodes the block, the one pixel more than there are deltas is left at the minimum.
 	for i := 0; i < pixelBlockSize; i++ {
 		switch i {
 		case int(p.maxidx):
@@ -573,18 +682,24 @@ func (p crawPixelBlock) Decompress() [pixelBlockSize]pixel {
 		case int(p.minidx):
 			pix[i] = pixel(p.min)
 		default:
-			pix[i] = pixel(p.min) + pixel(p.pix[ordinary]<<factor)
+			pix[i] = pixel(p.min)
+			if ordinary < len(p.pix) {
+				pix[i] += pixel(p.pix[ordinary]) << shift
+			}
+			if pix[i] > 0x7ff {
+				pix[i] = 0x7ff
+			}
 			ordinary++
 		}
 	}
-	return pix
+	return pix, nil
 }
 
 //readCrawBlock reads a 16 byte compressed CRAW block in to a workable datastructure.
-func readCrawBlock(s []byte) crawPixelBlock {
+func readCrawBlock(s []byte, order binary.ByteOrder) crawPixelBlock {
 	var p crawPixelBlock
 
-	val := b.Uint32(s)
+	val := order.Uint32(s)
 	max := uint16(0x7ff & (val >> 0))
 	min := uint16(0x7ff & (val >> 11))
 	maxidx := uint8(0x0f & (val >> 22))
@@ -593,12 +708,12 @@ func readCrawBlock(s []byte) crawPixelBlock {
 	for bit, i := 30, 0; i < len(p.pix); i++ {
 		var val uint16
 		if bit>>3 != 15 { // We will read off the end of the slice if we read a uint16 at the last byte
-			val = b.Uint16(s[bit>>3:])
+			val = order.Uint16(s[bit>>3:])
 		} else {
 			val = uint16(s[15])
 		}
 
-		p.pix[i] = uint8(val >> uint((bit&0x7)&0x7f))
+		p.pix[i] = uint8(val>>uint(bit&0x7)) & 0x7f
 		bit += 7
 	}
 
@@ -1,83 +1,82 @@
 package arw
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/png"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/Soreil/arw/internal/arwtest"
 )
 
 const testFileLocation = "samples"
 
-var samples map[sonyRawFile][]string
+var samples map[RawFormat][]string
 
 func init() {
 	os.Chdir(testFileLocation)
 
-	samples = make(map[sonyRawFile][]string)
+	samples = make(map[RawFormat][]string)
 
-	samples[raw14] = append(samples[raw14], `Y-a7r-iii-DSC00024`)
-	samples[raw14] = append(samples[raw14], `4379231197`)
-	samples[raw14] = append(samples[raw14], `4538279284`)
-	samples[raw14] = append(samples[raw14], `5132423552`)
+	samples[Raw14] = append(samples[Raw14], `Y-a7r-iii-DSC00024`)
+	samples[Raw14] = append(samples[Raw14], `4379231197`)
+	samples[Raw14] = append(samples[Raw14], `4538279284`)
+	samples[Raw14] = append(samples[Raw14], `5132423552`)
 
-	samples[raw12] = append(samples[raw12], `DSC01373`)
+	samples[Raw12] = append(samples[Raw12], `DSC01373`)
 
-	samples[craw] = append(samples[craw], `1`)
+	samples[CRAW] = append(samples[CRAW], `1`)
 }
 
-func TestDecodeA7R3(t *testing.T) {
-	samplename := samples[raw14][1]
-	testARW, err := os.Open(samplename + ".ARW")
+//openSample opens a camera file from the samples directory, the test is skipped when it isn't available.
+func openSample(t *testing.T, name string) *os.File {
+	f, err := os.Open(name + ".ARW")
+	if os.IsNotExist(err) {
+		t.Skip("Sample not available:", name)
+	}
 	if err != nil {
-		t.Error(err)
-		t.FailNow()
+		t.Fatal(err)
 	}
+	return f
+}
+
+func TestDecodeA7R3(t *testing.T) {
+	samplename := samples[Raw14][1]
+	testARW := openSample(t, samplename)
 
 	rw, err := extractDetails(testARW)
 	if err != nil {
 		t.Error(err)
 	}
 
-	buf := make([]byte, rw.length)
-	testARW.ReadAt(buf, int64(rw.offset))
-
-	if rw.rawType != raw14 {
+	if rw.rawType != Raw14 {
 		t.Error("Not yet implemented type:", rw.rawType)
 	}
 
 	start := time.Now()
 	t.Log(rw.gammaCurve)
-	readRaw14(buf, rw)
+	if _, err := readImage(testARW, rw, Options{Demosaicer: AHD{}}); err != nil {
+		t.Error(err)
+	}
 	t.Log("processing duration:", time.Now().Sub(start))
 }
 
 func TestViewer(t *testing.T) {
-	sampleName := samples[raw14][0]
-	sample, err := os.Open(sampleName + ".ARW")
-	if err != nil {
-		t.Error(err)
-	}
+	sampleName := samples[Raw14][0]
+	sample := openSample(t, sampleName)
 
 	rw, err := extractDetails(sample)
 	if err != nil {
 		t.Error(err)
 	}
 
-	buf := make([]byte, rw.length)
-	sample.ReadAt(buf, int64(rw.offset))
-
-	var rendered16bit *RGB14
-
-	switch rw.rawType {
-	case raw14:
-		rendered16bit = readRaw14(buf, rw)
-	case craw:
-		rendered16bit = readCRAW(buf, rw)
-	default:
-		t.Error("Unhanded RAW type:", rw.rawType)
+	rendered16bit, err := readImage(sample, rw, Options{Demosaicer: AHD{}, Upright: true})
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	asRGBA := image.NewRGBA(rendered16bit.Rect)
@@ -91,34 +90,300 @@ func TestViewer(t *testing.T) {
 }
 
 func TestProcessedPNG(t *testing.T) {
-	sampleName := samples[raw14][0]
-	sample, err := os.Open(sampleName + ".ARW")
+	sampleName := samples[Raw14][0]
+	sample := openSample(t, sampleName)
+
+	rw, err := extractDetails(sample)
 	if err != nil {
 		t.Error(err)
 	}
 
-	rw, err := extractDetails(sample)
+	rendered16bit, err := readImage(sample, rw, Options{Demosaicer: AHD{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const prefix = `16bitPNG`
+	f, err := os.Create("experiments/" + prefix + fmt.Sprint(time.Now().Unix()) + ".png")
 	if err != nil {
 		t.Error(err)
 	}
+	defer f.Close()
 
-	buf := make([]byte, rw.length)
-	sample.ReadAt(buf, int64(rw.offset))
+	png.Encode(f, rendered16bit)
+}
+
+func TestProcessedTIFF(t *testing.T) {
+	sampleName := samples[Raw14][0]
+	sample := openSample(t, sampleName)
 
-	var rendered16bit *RGB14
-	if rw.rawType == raw14 {
-		rendered16bit = readRaw14(buf, rw)
+	rw, err := extractDetails(sample)
+	if err != nil {
+		t.Error(err)
 	}
-	if rw.rawType == craw {
-		rendered16bit = readCRAW(buf, rw)
+
+	rendered16bit, err := readImage(sample, rw, Options{Demosaicer: AHD{}, Upright: true})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	const prefix = `16bitPNG`
-	f, err := os.Create("experiments/" + prefix + fmt.Sprint(time.Now().Unix()) + ".png")
+	const prefix = `16bitTIFF`
+	f, err := os.Create("experiments/" + prefix + fmt.Sprint(time.Now().Unix()) + ".tif")
 	if err != nil {
 		t.Error(err)
 	}
 	defer f.Close()
 
-	png.Encode(f, rendered16bit)
+	if err := EncodeTIFF(f, rendered16bit, sample, &TIFFOptions{Compression: TIFFDeflate}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnpackRaw12(t *testing.T) {
+	//Two rows of two pixels: 0x123, 0x456 and 0xfff, 0x000
+	packed := []byte{0x23, 0x61, 0x45, 0xff, 0x0f, 0x00}
+	expected := []uint16{0x123, 0x456, 0xfff, 0x000}
+
+	data := unpackRaw12(packed, 2, 2)
+	for i := range expected {
+		if data[i] != expected[i] {
+			t.Errorf("Pixel %v: expected %#x, got %#x", i, expected[i], data[i])
+		}
+	}
+}
+
+func TestUnpackRaw12OddWidth(t *testing.T) {
+	//Two rows of three pixels, the last pixel of each row fills a byte and the low nibble of the next.
+	packed := []byte{0x23, 0x61, 0x45, 0x89, 0x07, 0xff, 0x0f, 0x00, 0xbc, 0x0a}
+	expected := []uint16{0x123, 0x456, 0x789, 0xfff, 0x000, 0xabc}
+
+	data := unpackRaw12(packed, 3, 2)
+	for i := range expected {
+		if data[i] != expected[i] {
+			t.Errorf("Pixel %v: expected %#x, got %#x", i, expected[i], data[i])
+		}
+	}
+
+	file := syntheticFile(arwtest.Raw12)
+	file.Width = 63
+	file.Pixels = file.Pixels[:file.Width*file.Height]
+	img, err := Decode(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != file.Width {
+		t.Fatalf("Expected a width of %v, got %v", file.Width, bounds.Dx())
+	}
+	packed = arwtest.PackRaw12(file.Pixels, file.Width, file.Height)
+	data = unpackRaw12(packed, file.Width, file.Height)
+	for i := range file.Pixels {
+		if data[i] != file.Pixels[i] {
+			t.Fatalf("Pixel %v: expected %v, got %v", i, file.Pixels[i], data[i])
+		}
+	}
+}
+
+//syntheticFile returns a file with a gradient mosaic in which every CRAW block spans less than 128 values,
+//so all formats store it without loss.
+func syntheticFile(format arwtest.Format) arwtest.File {
+	const width, height = 64, 6
+	mosaic := make([]uint16, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mosaic[y*width+x] = uint16(0x200 + 0x40*y + x%32*3 + x/32)
+		}
+	}
+	return arwtest.File{
+		Width:        width,
+		Height:       height,
+		Format:       format,
+		Pixels:       mosaic,
+		Curve:        [4]uint16{0x2000, 0x2800, 0x3000, 0x3800},
+		BlackLevel:   [4]uint16{128, 128, 128, 128},
+		WhiteBalance: [4]int16{2400, 1024, 1024, 1800},
+	}
+}
+
+func TestSyntheticDetails(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	rw, err := extractDetails(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(rw.width) != file.Width || int(rw.height) != file.Height || rw.rawType != Raw14 || rw.bitDepth != 14 {
+		t.Errorf("Unexpected raw details %+v", rw)
+	}
+	if rw.blackLevel != file.BlackLevel {
+		t.Error("Expected black level", file.BlackLevel, "got:", rw.blackLevel)
+	}
+	if rw.WhiteBalance != file.WhiteBalance {
+		t.Error("Expected white balance", file.WhiteBalance, "got:", rw.WhiteBalance)
+	}
+	if rw.gammaCurve != [5]uint16{0x2000, 0x2800, 0x3000, 0x3800, 0x3fff} {
+		t.Error("Unexpected curve:", rw.gammaCurve)
+	}
+	if !bytes.Equal(rw.cfaPattern, []uint8{0, 1, 1, 2}) || rw.cfaPatternDim != [2]uint16{2, 2} {
+		t.Error("Expected RGGB, got:", rw.cfaPattern, rw.cfaPatternDim)
+	}
+}
+
+func TestSyntheticUnpack(t *testing.T) {
+	for _, format := range []arwtest.Format{arwtest.Raw14, arwtest.Raw12, arwtest.CRAW, arwtest.CRAWLossless} {
+		file := syntheticFile(format)
+		r := bytes.NewReader(file.Bytes())
+		rw, err := extractDetails(r)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		buf := make([]byte, rw.length)
+		if _, err := r.ReadAt(buf, int64(rw.offset)); err != nil {
+			t.Fatal(format, err)
+		}
+
+		expected := file.Pixels
+		var data []uint16
+		switch format {
+		case arwtest.Raw14:
+			data, err = unpackRaw14(buf, rw)
+		case arwtest.Raw12:
+			data = unpackRaw12(buf, file.Width, file.Height)
+		case arwtest.CRAW:
+			data, err = unpackCRAW(buf, rw)
+			curve := sonyCurveLUT(rw.gammaCurve)
+			expected = make([]uint16, len(file.Pixels))
+			for i, v := range file.Pixels {
+				expected[i] = curve[v]
+			}
+		case arwtest.CRAWLossless:
+			data, _, err = unpackLossless(r, rw)
+		}
+		if err != nil {
+			t.Fatal(format, err)
+		}
+
+		for i := range expected {
+			if data[i] != expected[i] {
+				t.Errorf("Format %v, pixel %v: expected %v, got %v", format, i, expected[i], data[i])
+				break
+			}
+		}
+	}
+}
+
+func TestCRAWQuantization(t *testing.T) {
+	//Blocks spanning more than 7 bits lose the lowest bits of their deltas.
+	const width, height = 32, 1
+	mosaic := make([]uint16, width)
+	for x := range mosaic {
+		mosaic[x] = uint16(x * 61)
+	}
+	file := arwtest.File{Width: width, Height: height, Format: arwtest.CRAW, Pixels: mosaic}
+	r := bytes.NewReader(file.Bytes())
+	rw, err := extractDetails(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, rw.length)
+	r.ReadAt(buf, int64(rw.offset))
+
+	data, err := unpackCRAW(buf, rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	curve := sonyCurveLUT(rw.gammaCurve)
+	for i, v := range arwtest.QuantizeCRAW(mosaic, width, height) {
+		if data[i] != curve[v] {
+			t.Errorf("Pixel %v: expected %v, got %v", i, curve[v], data[i])
+		}
+	}
+}
+
+func TestCRAWBlock(t *testing.T) {
+	//The expected pixels were computed by the inner loop of sony_arw2_load_raw from dcraw 9.28, before the curve
+	//is applied, for blocks with a shift of 0, 2 and 4. The third block has a delta clamped to 0x7ff, the last two
+	//are malformed blocks dcraw decodes anyway.
+	blocks := []struct {
+		data     []byte
+		expected [pixelBlockSize]pixel
+	}{
+		{
+			[]byte{0xf0, 0xc3, 0x1b, 0xe1, 0x23, 0x36, 0xf4, 0xef, 0x1d, 0xeb, 0x8a, 0x0c, 0x96, 0xe1, 0xe4, 0xe4},
+			[pixelBlockSize]pixel{903, 937, 955, 1014, 1008, 1011, 902, 995, 888, 909, 938, 936, 913, 916, 945, 1002},
+		},
+		{
+			[]byte{0x4a, 0x3c, 0x16, 0x4c, 0x4d, 0xb8, 0xc8, 0x61, 0xa5, 0x13, 0xc9, 0xe6, 0x4e, 0x5a, 0xd3, 0xaf},
+			[pixelBlockSize]pixel{1098, 923, 975, 711, 755, 939, 1063, 1039, 787, 783, 819, 1187, 855, 1139, 1175, 1059},
+		},
+		{
+			[]byte{0xb1, 0x7c, 0x84, 0xf6, 0xd0, 0xaa, 0xaa, 0x5a, 0xd8, 0x15, 0x7d, 0x12, 0xca, 0xdc, 0x72, 0xa6},
+			[pixelBlockSize]pixel{1215, 1519, 815, 1503, 495, 1871, 479, 2047, 1311, 1423, 1201, 1359, 1599, 143, 591, 1471},
+		},
+		//A flat block storing the same index for its maximum and minimum.
+		{
+			[]byte{0xf4, 0xa1, 0xcf, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			[pixelBlockSize]pixel{500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 500},
+		},
+		//A block whose maximum is below its minimum.
+		{
+			[]byte{0x64, 0x40, 0x06, 0x04, 0x8e, 0x9b, 0xa8, 0xb5, 0xc2, 0xcf, 0xdc, 0xe9, 0xf6, 0x03, 0x10, 0x1d},
+			[pixelBlockSize]pixel{100, 200, 256, 292, 209, 253, 245, 297, 279, 257, 239, 255, 263, 200, 268, 214},
+		},
+	}
+	for n, block := range blocks {
+		pix, err := readCrawBlock(block.data, binary.LittleEndian).Decompress()
+		if err != nil {
+			t.Fatal(n, err)
+		}
+		if pix != block.expected {
+			t.Errorf("Block %v: expected %v, got %v", n, block.expected, pix)
+		}
+	}
+}
+
+func TestSyntheticDecode(t *testing.T) {
+	for _, format := range []arwtest.Format{arwtest.Raw14, arwtest.Raw12, arwtest.CRAW, arwtest.CRAWLossless} {
+		file := syntheticFile(format)
+		for i := range file.Pixels {
+			file.Pixels[i] = 0
+		}
+
+		img, err := Decode(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		if img.Bounds() != image.Rect(0, 0, file.Width, file.Height) {
+			t.Fatal(format, "unexpected bounds:", img.Bounds())
+		}
+		for y := 0; y < file.Height; y++ {
+			for x := 0; x < file.Width; x++ {
+				if r, g, b, _ := img.At(x, y).RGBA(); r != 0 || g != 0 || b != 0 {
+					t.Fatalf("Format %v: expected a black frame, got %v %v %v at %v,%v", format, r, g, b, x, y)
+				}
+			}
+		}
+	}
+
+	//The stored values mean something different for every format, each flat frame only has to come out flat and grey.
+	for _, format := range []arwtest.Format{arwtest.Raw14, arwtest.Raw12, arwtest.CRAW, arwtest.CRAWLossless} {
+		file := syntheticFile(format)
+		for i := range file.Pixels {
+			file.Pixels[i] = 0x400
+		}
+
+		img, err := Decode(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		grey := img.At(0, 0)
+		if r, g, b, _ := grey.RGBA(); r == 0 || g == 0 || b == 0 {
+			t.Fatalf("Format %v: expected a grey frame, got %v", format, grey)
+		}
+		for y := 0; y < file.Height; y++ {
+			for x := 0; x < file.Width; x++ {
+				if c := img.At(x, y); c != grey {
+					t.Fatalf("Format %v: expected a flat frame of %v, got %v at %v,%v", format, grey, c, x, y)
+				}
+			}
+		}
+	}
 }
@@ -0,0 +1,174 @@
+package arw
+
+import (
+	"image"
+	"image/color"
+)
+
+//CFAColor is the colour of the filter in front of a single photosite, the values match the CFAPattern tag.
+type CFAColor uint8
+
+const (
+	CFARed CFAColor = iota
+	CFAGreen
+	CFABlue
+)
+
+//Bayer is the unprocessed sensor mosaic, a single channel image holding the values exactly as they were recorded.
+//CRAW values are expanded through the Sony curve so they are linear like the other formats.
+type Bayer struct {
+	Pix []uint16
+	//Stride is the Pix stride between vertically adjacent pixels.
+	Stride int
+	//Rect is the image's bounds.
+	Rect image.Rectangle
+
+	//Pattern holds the colours of the repeating CFA block in row-major order, PatternDim holds its rows and columns.
+	//Only the four phases of a 2x2 Bayer pattern are supported.
+	Pattern    [4]CFAColor
+	PatternDim [2]int
+	//BlackLevel holds the black level of every position in the CFA block, in the same order as Pattern.
+	BlackLevel [4]uint16
+	//WhiteLevel holds the value at which every position in the CFA block saturates, in the same order as Pattern.
+	WhiteLevel [4]uint16
+	//ActiveArea is the part of the sensor holding image data.
+	ActiveArea image.Rectangle
+	//Crop is the part of ActiveArea the camera presents as the image.
+	Crop image.Rectangle
+}
+
+//newBayer wraps an unpacked mosaic with the CFA layout and levels found in the file.
+func newBayer(data []uint16, rw rawDetails, white uint16) (*Bayer, error) {
+	pattern, err := bayerPattern(rw.cfaPattern, rw.cfaPatternDim)
+	if err != nil {
+		return nil, err
+	}
+
+	rect := image.Rect(0, 0, int(rw.width), int(rw.height))
+	b := &Bayer{
+		Pix:        data,
+		Stride:     int(rw.width),
+		Rect:       rect,
+		Pattern:    pattern,
+		PatternDim: [2]int{2, 2},
+		ActiveArea: rect,
+		Crop:       rect,
+	}
+	if !rw.activeArea.Empty() {
+		b.ActiveArea, b.Crop = rw.activeArea, rw.crop
+	}
+	for i, n := range rggbIndex(pattern) {
+		b.BlackLevel[i] = rw.blackLevel[n]
+	}
+	levels := whiteLevels(rw.whiteLevel, white)
+	for i, c := range pattern {
+		b.WhiteLevel[i] = levels[c]
+	}
+	return b, nil
+}
+
+//whiteLevels returns the R, G, B white levels from the WhiteLevel tag, scaled down to the largest value the format can hold.
+//Some bodies give them in 14 bit terms for 12 bit data. Without the tag every channel saturates at max.
+func whiteLevels(tag [3]uint16, max uint16) [3]uint16 {
+	var levels [3]uint16
+	for c, v := range tag {
+		if v == 0 {
+			v = max
+		}
+		for v > max {
+			v >>= 1
+		}
+		levels[c] = v
+	}
+	return levels
+}
+
+//bayerPattern checks the CFA layout from the file is one of the four phases of a 2x2 Bayer pattern.
+func bayerPattern(cfa []uint8, dim [2]uint16) ([4]CFAColor, error) {
+	var pattern [4]CFAColor
+	if dim != [2]uint16{2, 2} || len(cfa) != len(pattern) {
+		return pattern, ErrCFAPattern
+	}
+
+	var count [3]int
+	for i, c := range cfa {
+		if c > uint8(CFABlue) {
+			return pattern, ErrCFAPattern
+		}
+		pattern[i] = CFAColor(c)
+		count[c]++
+	}
+	//The greens have to sit on a diagonal, which leaves red and blue on the other.
+	if count != [3]int{1, 2, 1} || (pattern[0] != pattern[3] && pattern[1] != pattern[2]) {
+		return pattern, ErrCFAPattern
+	}
+	return pattern, nil
+}
+
+//rggbIndex maps every position in the CFA block to the index of its colour in values Sony stores in R, G, G, B order.
+//The first green in the block takes the first green value.
+func rggbIndex(pattern [4]CFAColor) [4]int {
+	var index [4]int
+	green := 1
+	for i, c := range pattern {
+		switch c {
+		case CFARed:
+			index[i] = 0
+		case CFAGreen:
+			index[i] = green
+			green++
+		case CFABlue:
+			index[i] = 3
+		}
+	}
+	return index
+}
+
+func (b *Bayer) ColorModel() color.Model {
+	return color.Gray16Model
+}
+
+func (b *Bayer) Bounds() image.Rectangle {
+	return b.Rect
+}
+
+func (b *Bayer) At(x, y int) color.Color {
+	return b.Gray16At(x, y)
+}
+
+//Gray16At returns the value recorded by the photosite at x, y.
+func (b *Bayer) Gray16At(x, y int) color.Gray16 {
+	if !(image.Point{x, y}.In(b.Rect)) {
+		return color.Gray16{}
+	}
+	return color.Gray16{Y: b.Pix[b.PixOffset(x, y)]}
+}
+
+//PixOffset returns the index of the pixel at x, y in Pix.
+func (b *Bayer) PixOffset(x, y int) int {
+	return (y-b.Rect.Min.Y)*b.Stride + (x - b.Rect.Min.X)
+}
+
+//ColorAt returns the colour of the filter in front of the photosite at x, y.
+func (b *Bayer) ColorAt(x, y int) CFAColor {
+	return b.Pattern[b.patternIndex(x, y)]
+}
+
+//BlackAt returns the black level of the photosite at x, y.
+func (b *Bayer) BlackAt(x, y int) uint16 {
+	return b.BlackLevel[b.patternIndex(x, y)]
+}
+
+//WhiteAt returns the white level of the photosite at x, y.
+func (b *Bayer) WhiteAt(x, y int) uint16 {
+	return b.WhiteLevel[b.patternIndex(x, y)]
+}
+
+//patternIndex returns the position of x, y in the CFA block, the block starts at the top left of the sensor.
+func (b *Bayer) patternIndex(x, y int) int {
+	rows, cols := b.PatternDim[0], b.PatternDim[1]
+	if rows <= 0 || cols <= 0 || rows*cols > len(b.Pattern) {
+		rows, cols = 2, 2
+	}
+	return (y%rows)*cols + x%cols
+}
@@ -0,0 +1,203 @@
+package arw
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+func TestDecodeBayer(t *testing.T) {
+	whiteLevels := map[arwtest.Format]uint16{
+		arwtest.Raw14:        0x3fff,
+		arwtest.Raw12:        0xfff,
+		arwtest.CRAW:         0x3fff,
+		arwtest.CRAWLossless: 0x3fff,
+	}
+
+	for format, white := range whiteLevels {
+		file := syntheticFile(format)
+		b, err := DecodeBayer(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatal(format, err)
+		}
+
+		if b.Rect != image.Rect(0, 0, file.Width, file.Height) || b.ActiveArea != b.Rect {
+			t.Errorf("Format %v: unexpected bounds %v, active area %v", format, b.Rect, b.ActiveArea)
+		}
+		if b.WhiteLevel != [4]uint16{white, white, white, white} {
+			t.Errorf("Format %v: expected white level %#x, got %#x", format, white, b.WhiteLevel)
+		}
+		if b.BlackLevel != file.BlackLevel {
+			t.Errorf("Format %v: expected black level %v, got %v", format, file.BlackLevel, b.BlackLevel)
+		}
+		if b.Pattern != [4]CFAColor{CFARed, CFAGreen, CFAGreen, CFABlue} || b.PatternDim != [2]int{2, 2} {
+			t.Errorf("Format %v: expected RGGB, got %v %v", format, b.Pattern, b.PatternDim)
+		}
+
+		expected := file.Pixels
+		if format == arwtest.CRAW {
+			curve := sonyCurveLUT([5]uint16{file.Curve[0], file.Curve[1], file.Curve[2], file.Curve[3], 0x3fff})
+			expected = make([]uint16, len(file.Pixels))
+			for i, v := range file.Pixels {
+				expected[i] = curve[v]
+			}
+		}
+		for y := 0; y < file.Height; y++ {
+			for x := 0; x < file.Width; x++ {
+				if v := b.Gray16At(x, y).Y; v != expected[y*file.Width+x] {
+					t.Fatalf("Format %v: pixel %v,%v expected %v, got %v", format, x, y, expected[y*file.Width+x], v)
+				}
+			}
+		}
+	}
+}
+
+func TestBayerColorAt(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	file.CFAPattern = [4]uint8{1, 2, 0, 1}
+	//Sony stores the black levels in R, G, G, B order whatever the layout of the sensor.
+	file.BlackLevel = [4]uint16{10, 20, 30, 40}
+
+	b, err := DecodeBayer(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		x, y  int
+		color CFAColor
+		black uint16
+	}{
+		{0, 0, CFAGreen, 20},
+		{1, 0, CFABlue, 40},
+		{0, 1, CFARed, 10},
+		{1, 1, CFAGreen, 30},
+		{4, 2, CFAGreen, 20},
+		{7, 5, CFAGreen, 30},
+	}
+	for _, e := range expected {
+		if c := b.ColorAt(e.x, e.y); c != e.color {
+			t.Errorf("%v,%v: expected colour %v, got %v", e.x, e.y, e.color, c)
+		}
+		if black := b.BlackAt(e.x, e.y); black != e.black {
+			t.Errorf("%v,%v: expected black level %v, got %v", e.x, e.y, e.black, black)
+		}
+	}
+}
+
+func TestDecodeCFAPhases(t *testing.T) {
+	//Every colour is exposed so that the white balance turns it in to the same grey.
+	file := syntheticFile(arwtest.Raw14)
+	exposure := [3]uint16{128 + 768, 128 + 1800, 128 + 1024}
+
+	for _, pattern := range [][4]uint8{{0, 1, 1, 2}, {2, 1, 1, 0}, {1, 0, 2, 1}, {1, 2, 0, 1}} {
+		file.CFAPattern = pattern
+		file.Pixels = make([]uint16, file.Width*file.Height)
+		for y := 0; y < file.Height; y++ {
+			for x := 0; x < file.Width; x++ {
+				file.Pixels[y*file.Width+x] = exposure[pattern[y%2*2+x%2]]
+			}
+		}
+
+		img, err := Decode(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatal(pattern, err)
+		}
+		for i, px := range img.(*RGB14).Pix {
+			if px.R != px.G || px.G != px.B {
+				t.Fatalf("Pattern %v: pixel %v expected grey, got %v", pattern, i, px)
+			}
+		}
+	}
+}
+
+func TestUnsupportedCFA(t *testing.T) {
+	const tagCFARepeatPatternDim, tagCFAPattern2 = 0x828d, 0x828e
+
+	for _, tags := range [][]arwtest.Tag{
+		{arwtest.Byte(tagCFAPattern2, 0, 0, 1, 2)},
+		{arwtest.Byte(tagCFAPattern2, 0, 1, 2, 1)},
+		{arwtest.Byte(tagCFAPattern2, 0, 1, 1, 3)},
+		{arwtest.Byte(tagCFAPattern2, 0, 1, 1)},
+		{arwtest.Short(tagCFARepeatPatternDim, 2, 3), arwtest.Byte(tagCFAPattern2, 0, 1, 2, 1, 2, 0)},
+	} {
+		file := syntheticFile(arwtest.Raw14)
+		file.RawTags = tags
+		if _, err := DecodeBayer(bytes.NewReader(file.Bytes())); err != ErrCFAPattern {
+			t.Errorf("%v: expected %v, got %v", tags, ErrCFAPattern, err)
+		}
+		if _, err := Decode(bytes.NewReader(file.Bytes())); err != ErrCFAPattern {
+			t.Errorf("%v: expected %v, got %v", tags, ErrCFAPattern, err)
+		}
+	}
+}
+
+func TestWhiteLevel(t *testing.T) {
+	const tagWhiteLevel = 0x787f
+
+	expected := []struct {
+		format arwtest.Format
+		tag    []uint16
+		white  [3]uint16
+	}{
+		{arwtest.Raw14, nil, [3]uint16{0x3fff, 0x3fff, 0x3fff}},
+		{arwtest.Raw14, []uint16{15360, 16300, 15800}, [3]uint16{15360, 16300, 15800}},
+		{arwtest.Raw14, []uint16{12000}, [3]uint16{12000, 12000, 12000}},
+		{arwtest.Raw12, nil, [3]uint16{0xfff, 0xfff, 0xfff}},
+		//Given in 14 bit terms for 12 bit data.
+		{arwtest.Raw12, []uint16{15360, 16300, 15800}, [3]uint16{3840, 4075, 3950}},
+	}
+	for _, e := range expected {
+		file := syntheticFile(e.format)
+		file.CFAPattern = [4]uint8{2, 1, 1, 0}
+		if e.tag != nil {
+			file.RawTags = []arwtest.Tag{arwtest.Short(tagWhiteLevel, e.tag...)}
+		}
+
+		meta, err := ReadMetadata(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.WhiteLevel != e.white {
+			t.Errorf("%v, %v: expected metadata white level %v, got %v", e.format, e.tag, e.white, meta.WhiteLevel)
+		}
+
+		b, err := DecodeBayer(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if white := [4]uint16{e.white[2], e.white[1], e.white[1], e.white[0]}; b.WhiteLevel != white {
+			t.Errorf("%v, %v: expected white levels %v for BGGR, got %v", e.format, e.tag, white, b.WhiteLevel)
+		}
+	}
+}
+
+func TestDecodeWhiteLevel(t *testing.T) {
+	//A sensor saturating well below the 14 bit range still has to develop to white.
+	const tagWhiteLevel = 0x787f
+
+	for _, format := range []arwtest.Format{arwtest.Raw14, arwtest.Raw12} {
+		file := syntheticFile(format)
+		file.WhiteBalance = [4]int16{1024, 1024, 1024, 1024}
+		white := uint16(12000)
+		if format == arwtest.Raw12 {
+			white = 3000
+		}
+		file.RawTags = []arwtest.Tag{arwtest.Short(tagWhiteLevel, white, white, white)}
+		for i := range file.Pixels {
+			file.Pixels[i] = white
+		}
+
+		img, err := Decode(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, px := range img.(*RGB14).Pix {
+			if px != (pixel16{R: 0x3fff, G: 0x3fff, B: 0x3fff}) {
+				t.Fatalf("Format %v: pixel %v expected white, got %v", format, i, px)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package arw
+
+import "math"
+
+//RGBSpace is the RGB colour space the developed image is converted to.
+//go:generate stringer -type=RGBSpace
+type RGBSpace uint8
+
+const (
+	//SRGB is IEC 61966-2-1 sRGB with a D65 white point, the default.
+	SRGB RGBSpace = iota
+	//AdobeRGB is Adobe RGB (1998) with a D65 white point.
+	AdobeRGB
+	//ProPhotoRGB is ROMM RGB with a D50 white point.
+	ProPhotoRGB
+	//CameraRGB leaves the white balanced camera values untouched.
+	CameraRGB
+)
+
+//matrix3 is a row-major 3x3 matrix.
+type matrix3 [3][3]float64
+
+var identity3 = matrix3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+func (m matrix3) mul(n matrix3) matrix3 {
+	var p matrix3
+	for i := range p {
+		for j := range p[i] {
+			for k := range n {
+				p[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return p
+}
+
+func (m matrix3) apply(v [3]float64) [3]float64 {
+	var p [3]float64
+	for i := range p {
+		p[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return p
+}
+
+//inverse returns the inverse of m, or false when m is singular.
+func (m matrix3) inverse() (matrix3, bool) {
+	var inv matrix3
+	for i := range inv {
+		for j := range inv[i] {
+			//The cofactor of the transposed element, the cyclic indices take care of the sign.
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			inv[i][j] = m[a][c]*m[b][d] - m[a][d]*m[b][c]
+		}
+	}
+	det := m[0][0]*inv[0][0] + m[0][1]*inv[1][0] + m[0][2]*inv[2][0]
+	if math.Abs(det) < 1e-12 {
+		return identity3, false
+	}
+	for i := range inv {
+		for j := range inv[i] {
+			inv[i][j] /= det
+		}
+	}
+	return inv, true
+}
+
+//diagonal returns the matrix scaling every component of a vector by v.
+func diagonal(v [3]float64) matrix3 {
+	return matrix3{{v[0], 0, 0}, {0, v[1], 0}, {0, 0, v[2]}}
+}
+
+//White points in XYZ normalised to Y = 1.
+var (
+	whiteD65 = [3]float64{0.95047, 1, 1.08883}
+	whiteD50 = [3]float64{0.96422, 1, 0.82521}
+)
+
+//Matrices taking linear RGB to XYZ relative to the white point of the space.
+var (
+	sRGBToXYZ = matrix3{
+		{0.4124564, 0.3575761, 0.1804375},
+		{0.2126729, 0.7151522, 0.0721750},
+		{0.0193339, 0.1191920, 0.9503041},
+	}
+	adobeRGBToXYZ = matrix3{
+		{0.5767309, 0.1855540, 0.1881852},
+		{0.2973769, 0.6273491, 0.0752741},
+		{0.0270343, 0.0706872, 0.9911085},
+	}
+	proPhotoRGBToXYZ = matrix3{
+		{0.7976749, 0.1351917, 0.0313534},
+		{0.2880402, 0.7118741, 0.0000857},
+		{0, 0, 0.8252100},
+	}
+)
+
+//bradford is the cone response matrix used for chromatic adaptation.
+var bradford = matrix3{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+//adapt returns the Bradford transform taking colours seen under the white point from to colours seen under to.
+func adapt(from, to [3]float64) matrix3 {
+	src, dst := bradford.apply(from), bradford.apply(to)
+	inv, _ := bradford.inverse()
+	return inv.mul(diagonal([3]float64{dst[0] / src[0], dst[1] / src[1], dst[2] / src[2]})).mul(bradford)
+}
+
+//adobeMatrices holds the XYZ to camera matrices published in the Adobe DNG converter for cameras whose files don't carry a ColorMatrix tag.
+//The values are scaled by 10000 and were measured under D65.
+var adobeMatrices = map[string][9]int16{
+	"DSLR-A100": {9437, -2811, -774, -8405, 16215, 2290, -710, 596, 7181},
+	"NEX-5N":    {5991, -1456, -455, -4764, 12135, 2980, -707, 1425, 6701},
+	"ILCE-6000": {5991, -1456, -455, -4764, 12135, 2980, -707, 1425, 6701},
+	"ILCE-7":    {5271, -712, -347, -6153, 13653, 2763, -1601, 2366, 7242},
+	"ILCE-7M2":  {5271, -712, -347, -6153, 13653, 2763, -1601, 2366, 7242},
+	"ILCE-7R":   {4913, -541, -202, -6130, 13513, 2906, -1564, 2151, 7183},
+	"ILCE-7RM2": {6629, -1900, -483, -4618, 12349, 2550, -622, 1381, 6514},
+	"ILCE-7S":   {5838, -1430, -246, -3497, 11477, 2297, -748, 1885, 5778},
+	"ILCE-7SM2": {5838, -1430, -246, -3497, 11477, 2297, -748, 1885, 5778},
+}
+
+//cameraToXYZ returns the matrix taking white balanced camera RGB to XYZ seen under D65.
+//Sony stores a matrix to sRGB in the ColorMatrix tag scaled by 1024. It is made for white balanced values, so it can't
+//tell which illuminant the balance was for and the scene white is taken to be D65.
+//Older files fall back to the Adobe matrix for the model. As in a DNG converter the scene white is then found from
+//the white balance levels and adapted to D65, which keeps colours right under light far from daylight.
+//Without either the camera values are taken to be sRGB.
+func cameraToXYZ(rw rawDetails) matrix3 {
+	if rw.colorMatrix != [9]int16{} {
+		var m matrix3
+		for i, v := range rw.colorMatrix {
+			m[i/3][i%3] = float64(v) / 1024
+		}
+		return sRGBToXYZ.mul(m)
+	}
+
+	xyzToCamera, ok := adobeMatrices[rw.model]
+	if !ok {
+		return sRGBToXYZ
+	}
+	var camera matrix3
+	for i, v := range xyzToCamera {
+		camera[i/3][i%3] = float64(v) / 10000
+	}
+	toXYZ, ok := camera.inverse()
+	if !ok {
+		return sRGBToXYZ
+	}
+
+	//The balance scales the camera response to the scene illuminant to camera white. Without usable levels the
+	//illuminant is taken to be D65.
+	neutral, ok := neutral(rw.WhiteBalance)
+	if !ok {
+		neutral = camera.apply(whiteD65)
+	}
+	scene := toXYZ.apply(neutral)
+	y := scene[1]
+	if y <= 0 {
+		return sRGBToXYZ
+	}
+	for i := range scene {
+		neutral[i] /= y
+		scene[i] /= y
+	}
+	return adapt(scene, whiteD65).mul(toXYZ).mul(diagonal(neutral))
+}
+
+//neutral returns the camera response to the scene illuminant, which the RGGB white balance levels bring to white.
+func neutral(levels [4]int16) ([3]float64, bool) {
+	green := (float64(levels[1]) + float64(levels[2])) / 2
+	if levels[0] <= 0 || green <= 0 || levels[3] <= 0 {
+		return [3]float64{}, false
+	}
+	return [3]float64{1 / float64(levels[0]), 1 / green, 1 / float64(levels[3])}, true
+}
+
+//colorTransform returns the matrix taking white balanced camera RGB to linear RGB in space.
+//The camera colours are taken to XYZ seen under D65 and from there adapted to the white point of the output space.
+func colorTransform(rw rawDetails, space RGBSpace) matrix3 {
+	var toXYZ matrix3
+	var white [3]float64
+	switch space {
+	case SRGB:
+		toXYZ, white = sRGBToXYZ, whiteD65
+	case AdobeRGB:
+		toXYZ, white = adobeRGBToXYZ, whiteD65
+	case ProPhotoRGB:
+		toXYZ, white = proPhotoRGBToXYZ, whiteD50
+	default:
+		return identity3
+	}
+
+	fromXYZ, _ := toXYZ.inverse()
+	return fromXYZ.mul(adapt(whiteD65, white)).mul(cameraToXYZ(rw))
+}
+
+//convert applies m to every pixel of img, clipping the result to the 14 bit range.
+func (m matrix3) convert(img *RGB14) {
+	if m == identity3 {
+		return
+	}
+	for i := range img.Pix {
+		px := &img.Pix[i]
+		v := m.apply([3]float64{float64(px.R), float64(px.G), float64(px.B)})
+		px.R, px.G, px.B = clip14(v[0]), clip14(v[1]), clip14(v[2])
+	}
+}
+
+func clip14(v float64) uint16 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 0x3fff:
+		return 0x3fff
+	}
+	return uint16(v + 0.5)
+}
@@ -0,0 +1,123 @@
+package arw
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+func TestMatrixInverse(t *testing.T) {
+	for _, m := range []matrix3{sRGBToXYZ, adobeRGBToXYZ, proPhotoRGBToXYZ, bradford} {
+		inv, ok := m.inverse()
+		if !ok {
+			t.Fatal("Expected an inverse for", m)
+		}
+		p := m.mul(inv)
+		for i := range p {
+			for j := range p[i] {
+				if math.Abs(p[i][j]-identity3[i][j]) > 1e-9 {
+					t.Fatalf("%v times its inverse gives %v", m, p)
+				}
+			}
+		}
+	}
+
+	if _, ok := (matrix3{{1, 2, 3}, {2, 4, 6}, {0, 0, 1}}).inverse(); ok {
+		t.Error("Expected a singular matrix to have no inverse")
+	}
+}
+
+func TestColorTransformWhite(t *testing.T) {
+	//Every row of the Sony matrix sums to 1024 so white balanced grey stays grey.
+	sony := rawDetails{colorMatrix: [9]int16{1533, -388, -121, -189, 1326, -113, 24, -435, 1435}}
+	adobe := rawDetails{model: "ILCE-7"}
+
+	for _, rw := range []rawDetails{sony, adobe, {}} {
+		for _, space := range []RGBSpace{SRGB, AdobeRGB, ProPhotoRGB, CameraRGB} {
+			white := colorTransform(rw, space).apply([3]float64{1, 1, 1})
+			for _, v := range white {
+				if math.Abs(v-1) > 1e-3 {
+					t.Errorf("%v, %q: expected white to stay white, got %v", space, rw.model, white)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestColorTransformIlluminant(t *testing.T) {
+	//A colour lit by tungsten light, balanced for it in camera, has to end up where Bradford adaptation to D65 puts it.
+	whiteA := [3]float64{1.09850, 1, 0.35585}
+	colour := [3]float64{0.3, 0.2, 0.05}
+
+	var xyzToCamera matrix3
+	for i, v := range adobeMatrices["ILCE-7"] {
+		xyzToCamera[i/3][i%3] = float64(v) / 10000
+	}
+	white := xyzToCamera.apply(whiteA)
+	rw := rawDetails{model: "ILCE-7"}
+	rw.WhiteBalance = [4]int16{int16(1024 * white[1] / white[0]), 1024, 1024, int16(1024 * white[1] / white[2])}
+
+	raw := xyzToCamera.apply(colour)
+	balanced := [3]float64{raw[0] * float64(rw.WhiteBalance[0]), raw[1] * float64(rw.WhiteBalance[1]), raw[2] * float64(rw.WhiteBalance[3])}
+	got := cameraToXYZ(rw).apply(balanced)
+	expected := adapt(whiteA, whiteD65).apply(colour)
+	//Exposure is lost along the way, only the chromaticity has to match.
+	for i := range got {
+		if math.Abs(got[i]/got[1]-expected[i]/expected[1]) > 1e-3 {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+
+	//Balanced for daylight the transform is the same as it was before the white balance was taken in to account.
+	daylight := rawDetails{model: "ILCE-7"}
+	white = xyzToCamera.apply(whiteD65)
+	daylight.WhiteBalance = [4]int16{int16(1024 * white[1] / white[0]), 1024, 1024, int16(1024 * white[1] / white[2])}
+	with, without := cameraToXYZ(daylight), cameraToXYZ(rawDetails{model: "ILCE-7"})
+	for i := range with {
+		for j := range with[i] {
+			if math.Abs(with[i][j]-without[i][j]) > 1e-2 {
+				t.Fatalf("Expected %v, got %v", without, with)
+			}
+		}
+	}
+}
+
+func TestSyntheticColorMatrix(t *testing.T) {
+	const tagColorMatrix = 0x7800
+
+	//A matrix swapping red and blue shows whether it is applied without depending on rounding.
+	file := syntheticFile(arwtest.Raw14)
+	file.RawTags = []arwtest.Tag{arwtest.SShort(tagColorMatrix, 0, 0, 1024, 0, 1024, 0, 1024, 0, 0)}
+
+	rw, err := extractDetails(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rw.colorMatrix != [9]int16{0, 0, 1024, 0, 1024, 0, 1024, 0, 0} {
+		t.Fatal("Unexpected colour matrix:", rw.colorMatrix)
+	}
+
+	camera, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{ColorSpace: CameraRGB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srgb, err := Decode(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var coloured bool
+	for i, p := range camera.(*RGB14).Pix {
+		q := srgb.(*RGB14).Pix[i]
+		if p.R != q.B || p.G != q.G || p.B != q.R {
+			t.Fatalf("Pixel %v: expected red and blue of %v swapped, got %v", i, p, q)
+		}
+		coloured = coloured || p.R != p.B
+	}
+	if !coloured {
+		t.Error("Expected the synthetic image to have colour")
+	}
+}
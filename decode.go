@@ -0,0 +1,171 @@
+package arw
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+)
+
+//arwMagic matches a little endian TIFF header followed by IFD0 at offset 8 whose first entry marks it as a
+//reduced resolution image and whose second is an old style JPEG Compression. Sony stores the preview in IFD0
+//through JPEGInterchangeFormat without any strips, so IFD0 goes straight from NewSubFileType to Compression.
+//DNGs and other TIFFs with a reduced resolution IFD0 have ImageWidth there, so registering this format does not
+//shadow the standard TIFF decoder.
+const arwMagic = "II*\x00\x08\x00\x00\x00??\xfe\x00\x04\x00\x01\x00\x00\x00\x01\x00\x00\x00\x03\x01\x03\x00\x01\x00\x00\x00\x06\x00"
+
+func init() {
+	image.RegisterFormat("arw", arwMagic, Decode, DecodeConfig)
+}
+
+//ErrNotARW is returned when a TIFF document doesn't contain a Sony raw SubIFD.
+var ErrNotARW = errors.New("not a Sony ARW file, no SonyRawFileType tag found")
+
+//ErrUnsupported is returned when the raw data is stored in a format we can't decode yet.
+var ErrUnsupported = errors.New("unsupported Sony raw format")
+
+//ErrCorrupt is returned when the raw data has no pixels or compressed raw data can't be decompressed.
+var ErrCorrupt = errors.New("corrupt raw data")
+
+//ErrNoPreview is returned when a file has no embedded JPEG preview.
+var ErrNoPreview = errors.New("no embedded preview")
+
+//ErrCFAPattern is returned when the sensor doesn't use one of the four phases of a 2x2 Bayer pattern.
+var ErrCFAPattern = errors.New("unsupported CFA pattern")
+
+type readSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+//asReadSeekerAt returns r if it supports random access, otherwise the whole stream is read in to memory.
+func asReadSeekerAt(r io.Reader) (readSeekerAt, error) {
+	if rs, ok := r.(readSeekerAt); ok {
+		return rs, nil
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf), nil
+}
+
+//Decode reads a Sony ARW file from r and returns the image developed with the default options.
+func Decode(r io.Reader) (image.Image, error) {
+	return DecodeWithOptions(r, nil)
+}
+
+//DecodeWithOptions reads a Sony ARW file from r and returns the image developed according to opts.
+func DecodeWithOptions(r io.Reader, opts *Options) (image.Image, error) {
+	rs, err := asReadSeekerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rw, err := extractDetails(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := readImage(rs, rw, opts.defaults())
+	if err != nil {
+		//Don't wrap a nil *RGB14 in a non nil image.Image.
+		return nil, err
+	}
+	return img, nil
+}
+
+func readImage(r io.ReaderAt, rw rawDetails, opts Options) (*RGB14, error) {
+	//An estimator overrides the other white balance options, a preset missing from the file doesn't matter then.
+	if opts.AutoWhiteBalance == nil {
+		wb, err := rw.whiteBalance(opts)
+		if err != nil {
+			return nil, err
+		}
+		rw.WhiteBalance = wb
+	}
+
+	b, err := readBayer(r, rw)
+	if err != nil {
+		return nil, err
+	}
+	if opts.AutoWhiteBalance != nil {
+		rw.WhiteBalance = opts.AutoWhiteBalance.EstimateWhiteBalance(b)
+	}
+	return develop(b, rw, opts), nil
+}
+
+//DecodeBayer reads a Sony ARW file from r and returns the raw sensor mosaic without any processing.
+func DecodeBayer(r io.Reader) (*Bayer, error) {
+	rs, err := asReadSeekerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rw, err := extractDetails(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	return readBayer(rs, rw)
+}
+
+//DecodePreview reads the largest JPEG preview embedded in a Sony ARW file from r. Only opts.Upright is used,
+//the preview is turned upright like the developed image would be.
+func DecodePreview(r io.Reader, opts *Options) (image.Image, error) {
+	rs, err := asReadSeekerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rw, err := extractDetails(rs)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rs.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	previews, err := Previews(rs)
+	if err != nil {
+		return nil, err
+	}
+	if len(previews) == 0 {
+		return nil, ErrNoPreview
+	}
+
+	p := largest(previews)
+	jpg, err := ExtractThumbnail(rs, p.Offset, p.Length)
+	if err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(jpg))
+	if err != nil {
+		return nil, err
+	}
+	if opts.defaults().Upright {
+		img = rw.orientation.applyImage(img)
+	}
+	return img, nil
+}
+
+//DecodeConfig returns the dimensions of the image Decode returns from an ARW file without decoding it.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	rs, err := asReadSeekerAt(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	rw, err := extractDetails(rs)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{
+		ColorModel: color.RGBA64Model,
+		Width:      rw.crop.Dx(),
+		Height:     rw.crop.Dy(),
+	}, nil
+}
@@ -0,0 +1,163 @@
+package arw
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+func TestImageDecode(t *testing.T) {
+	sampleName := samples[Raw14][0]
+	sample := openSample(t, sampleName)
+	defer sample.Close()
+
+	img, format, err := image.Decode(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "arw" {
+		t.Error("Expected arw format, got:", format)
+	}
+
+	sample.Seek(0, 0)
+	conf, err := DecodeConfig(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Width != img.Bounds().Dx() || conf.Height != img.Bounds().Dy() {
+		t.Errorf("Config %vx%v doesn't match decoded bounds %v", conf.Width, conf.Height, img.Bounds())
+	}
+}
+
+func TestPlainTIFFNotShadowed(t *testing.T) {
+	//A minimal little endian TIFF with a single IFD starting with ImageWidth, as written by most encoders.
+	plain := []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0,
+		1, 0,
+		0x00, 0x01, 3, 0, 1, 0, 0, 0, 16, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(plain))
+	if format == "arw" {
+		t.Error("Plain TIFF was claimed by the ARW decoder")
+	}
+	if err != image.ErrFormat {
+		t.Error("Expected unknown format error, got:", err)
+	}
+}
+
+func TestDNGNotShadowed(t *testing.T) {
+	//A DNG starts with a reduced resolution IFD0 as well, but one holding an uncompressed thumbnail.
+	dng := []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0,
+		3, 0,
+		0xfe, 0x00, 4, 0, 1, 0, 0, 0, 1, 0, 0, 0,
+		0x00, 0x01, 3, 0, 1, 0, 0, 0, 0, 1, 0, 0,
+		0x03, 0x01, 3, 0, 1, 0, 0, 0, 1, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	if _, format, _ := image.DecodeConfig(bytes.NewReader(dng)); format == "arw" {
+		t.Error("DNG was claimed by the ARW decoder")
+	}
+
+	file := syntheticFile(arwtest.Raw14)
+	if _, format, err := image.DecodeConfig(bytes.NewReader(file.Bytes())); err != nil || format != "arw" {
+		t.Errorf("Expected an ARW to be recognised, got %q: %v", format, err)
+	}
+}
+
+//cropFile has an active area leaving out the first row and the outer columns, with a default crop inside it.
+func cropFile() arwtest.File {
+	file := syntheticFile(arwtest.Raw14)
+	file.RawTags = []arwtest.Tag{
+		arwtest.Short(uint16(ActiveArea), 1, 2, 6, 62),
+		arwtest.Long(uint16(DefaultCropOrigin), 2, 1),
+		arwtest.Long(uint16(DefaultCropSize), 56, 4),
+	}
+	return file
+}
+
+func TestDefaultCrop(t *testing.T) {
+	file := cropFile()
+	crop := image.Rect(4, 2, 60, 6)
+
+	b, err := DecodeBayer(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ActiveArea != image.Rect(2, 1, 62, 6) || b.Crop != crop {
+		t.Errorf("Expected active area %v and crop %v, got %v and %v", image.Rect(2, 1, 62, 6), crop, b.ActiveArea, b.Crop)
+	}
+
+	conf, err := DecodeConfig(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Width != crop.Dx() || conf.Height != crop.Dy() {
+		t.Errorf("Expected a %vx%v config, got %vx%v", crop.Dx(), crop.Dy(), conf.Width, conf.Height)
+	}
+
+	img, err := Decode(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{Uncropped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, crop.Dx(), crop.Dy()) {
+		t.Error("Unexpected cropped bounds:", img.Bounds())
+	}
+	if full.Bounds() != image.Rect(0, 0, file.Width, file.Height) {
+		t.Error("Unexpected uncropped bounds:", full.Bounds())
+	}
+
+	cropped, uncropped := img.(*RGB14), full.(*RGB14)
+	for y := 0; y < crop.Dy(); y++ {
+		for x := 0; x < crop.Dx(); x++ {
+			px, expected := cropped.Pix[cropped.offset(x, y)], uncropped.Pix[uncropped.offset(x+crop.Min.X, y+crop.Min.Y)]
+			if px != expected {
+				t.Fatalf("Pixel %v,%v: expected %v, got %v", x, y, expected, px)
+			}
+		}
+	}
+}
+
+func TestInvalidCrop(t *testing.T) {
+	full := image.Rect(0, 0, 64, 6)
+	expected := []struct {
+		name   string
+		tags   []arwtest.Tag
+		active image.Rectangle
+		crop   image.Rectangle
+	}{
+		{"none", nil, full, full},
+		{"crop past the active area", []arwtest.Tag{
+			arwtest.Short(uint16(ActiveArea), 1, 2, 6, 62),
+			arwtest.Long(uint16(DefaultCropOrigin), 4, 0),
+			arwtest.Long(uint16(DefaultCropSize), 60, 4),
+		}, image.Rect(2, 1, 62, 6), image.Rect(2, 1, 62, 6)},
+		{"active area past the image", []arwtest.Tag{
+			arwtest.Short(uint16(ActiveArea), 0, 0, 8, 64),
+			arwtest.Long(uint16(DefaultCropSize), 60, 4),
+		}, full, image.Rect(0, 0, 60, 4)},
+		{"empty crop", []arwtest.Tag{
+			arwtest.Long(uint16(DefaultCropOrigin), 2, 2),
+			arwtest.Long(uint16(DefaultCropSize), 0, 4),
+		}, full, full},
+	}
+	for _, e := range expected {
+		file := syntheticFile(arwtest.Raw14)
+		file.RawTags = e.tags
+		rw, err := extractDetails(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatal(e.name, err)
+		}
+		if rw.activeArea != e.active || rw.crop != e.crop {
+			t.Errorf("%v: expected active area %v and crop %v, got %v and %v", e.name, e.active, e.crop, rw.activeArea, rw.crop)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package arw
+
+import (
+	"image"
+	"math"
+)
+
+//Demosaicer interpolates the two colours missing at every photosite of a Bayer mosaic.
+//The mosaic is linear with the black level subtracted, the returned image is in the same scale.
+type Demosaicer interface {
+	Demosaic(b *Bayer) *RGB14
+}
+
+//Bilinear averages the nearest photosites of every colour, it is fast but produces zipper artifacts along edges.
+type Bilinear struct{}
+
+//PPG is Patterned Pixel Grouping by Chuan-kai Lin, it interpolates green along the smoothest gradient first.
+type PPG struct{}
+
+//VNG is Variable Number of Gradients by Chang, Cheung and Pang, it averages over the directions with the smallest gradients.
+type VNG struct{}
+
+//AHD is Adaptive Homogeneity-Directed demosaicing by Hirakawa and Parks, it picks the direction whose
+//interpolation is most homogeneous in CIELAB. It is the slowest and produces the least artifacts.
+type AHD struct{}
+
+//channel returns the value of colour c.
+func (p *pixel16) channel(c CFAColor) *uint16 {
+	switch c {
+	case CFARed:
+		return &p.R
+	case CFABlue:
+		return &p.B
+	default:
+		return &p.G
+	}
+}
+
+//clip16 limits v to the range of a uint16.
+func clip16(v int) uint16 {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xffff {
+		return 0xffff
+	}
+	return uint16(v)
+}
+
+//ulim limits v to the range spanned by a and b.
+func ulim(v, a, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+	if v < a {
+		return a
+	}
+	if v > b {
+		return b
+	}
+	return v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+//newMosaicImage returns an image holding only the recorded colour of every photosite.
+func newMosaicImage(b *Bayer) *RGB14 {
+	img := NewRGB14(b.Rect)
+	for y := b.Rect.Min.Y; y < b.Rect.Max.Y; y++ {
+		for x := b.Rect.Min.X; x < b.Rect.Max.X; x++ {
+			*img.Pix[img.offset(x, y)].channel(b.ColorAt(x, y)) = b.Pix[b.PixOffset(x, y)]
+		}
+	}
+	return img
+}
+
+func (r *RGB14) offset(x, y int) int {
+	return (y-r.Rect.Min.Y)*r.Stride + (x - r.Rect.Min.X)
+}
+
+//bilinearAt averages the photosites of every missing colour in the 3x3 neighbourhood of x, y.
+func bilinearAt(b *Bayer, img *RGB14, x, y int) {
+	var sum, count [3]int
+	interior := image.Rect(x-1, y-1, x+2, y+2).In(b.Rect)
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			p := image.Point{x + dx, y + dy}
+			if !interior && !p.In(b.Rect) {
+				continue
+			}
+			c := b.ColorAt(p.X, p.Y)
+			sum[c] += int(b.Pix[b.PixOffset(p.X, p.Y)])
+			count[c]++
+		}
+	}
+
+	own := b.ColorAt(x, y)
+	px := &img.Pix[img.offset(x, y)]
+	for c := CFARed; c <= CFABlue; c++ {
+		if c != own && count[c] > 0 {
+			*px.channel(c) = uint16(sum[c] / count[c])
+		}
+	}
+}
+
+//borderInterpolate fills in the outermost border pixels of the image with bilinear interpolation.
+func borderInterpolate(b *Bayer, img *RGB14, border int) {
+	for y := b.Rect.Min.Y; y < b.Rect.Max.Y; y++ {
+		for x := b.Rect.Min.X; x < b.Rect.Max.X; x++ {
+			if x == b.Rect.Min.X+border && y >= b.Rect.Min.Y+border && y < b.Rect.Max.Y-border {
+				x = b.Rect.Max.X - border
+				if x < b.Rect.Min.X+border {
+					x = b.Rect.Min.X + border
+				}
+			}
+			bilinearAt(b, img, x, y)
+		}
+	}
+}
+
+func (Bilinear) Demosaic(b *Bayer) *RGB14 {
+	img := newMosaicImage(b)
+	for y := b.Rect.Min.Y; y < b.Rect.Max.Y; y++ {
+		for x := b.Rect.Min.X; x < b.Rect.Max.X; x++ {
+			bilinearAt(b, img, x, y)
+		}
+	}
+	return img
+}
+
+func (PPG) Demosaic(b *Bayer) *RGB14 {
+	img := newMosaicImage(b)
+	borderInterpolate(b, img, 3)
+
+	get := func(x, y int, c CFAColor) int {
+		return int(*img.Pix[img.offset(x, y)].channel(c))
+	}
+	r := b.Rect
+	dirs := [2]image.Point{{1, 0}, {0, 1}}
+
+	//Fill in green along the direction with the smallest gradient.
+	for y := r.Min.Y + 3; y < r.Max.Y-3; y++ {
+		for x := r.Min.X + 3; x < r.Max.X-3; x++ {
+			c := b.ColorAt(x, y)
+			if c == CFAGreen {
+				continue
+			}
+			var guess, diff [2]int
+			for i, d := range dirs {
+				at := func(n int, c CFAColor) int { return get(x+n*d.X, y+n*d.Y, c) }
+				guess[i] = (at(-1, CFAGreen)+at(0, c)+at(1, CFAGreen))*2 - at(-2, c) - at(2, c)
+				diff[i] = (abs(at(-2, c)-at(0, c))+abs(at(2, c)-at(0, c))+abs(at(-1, CFAGreen)-at(1, CFAGreen)))*3 +
+					(abs(at(3, CFAGreen)-at(1, CFAGreen))+abs(at(-3, CFAGreen)-at(-1, CFAGreen)))*2
+			}
+			i := 0
+			if diff[0] > diff[1] {
+				i = 1
+			}
+			d := dirs[i]
+			v := ulim(guess[i]>>2, get(x+d.X, y+d.Y, CFAGreen), get(x-d.X, y-d.Y, CFAGreen))
+			*img.Pix[img.offset(x, y)].channel(CFAGreen) = clip16(v)
+		}
+	}
+
+	//Red and blue for green photosites from the colour differences of the neighbours.
+	for y := r.Min.Y + 1; y < r.Max.Y-1; y++ {
+		for x := r.Min.X + 1; x < r.Max.X-1; x++ {
+			if b.ColorAt(x, y) != CFAGreen {
+				continue
+			}
+			for _, d := range dirs {
+				c := b.ColorAt(x+d.X, y+d.Y)
+				v := (get(x-d.X, y-d.Y, c) + get(x+d.X, y+d.Y, c) + 2*get(x, y, CFAGreen) -
+					get(x-d.X, y-d.Y, CFAGreen) - get(x+d.X, y+d.Y, CFAGreen)) >> 1
+				*img.Pix[img.offset(x, y)].channel(c) = clip16(v)
+			}
+		}
+	}
+
+	//Blue for red photosites and red for blue ones along the smoothest diagonal.
+	diagonals := [2]image.Point{{1, 1}, {-1, 1}}
+	for y := r.Min.Y + 1; y < r.Max.Y-1; y++ {
+		for x := r.Min.X + 1; x < r.Max.X-1; x++ {
+			own := b.ColorAt(x, y)
+			if own == CFAGreen {
+				continue
+			}
+			c := CFABlue - own
+			var guess, diff [2]int
+			for i, d := range diagonals {
+				a, z := image.Point{x - d.X, y - d.Y}, image.Point{x + d.X, y + d.Y}
+				diff[i] = abs(get(a.X, a.Y, c)-get(z.X, z.Y, c)) +
+					abs(get(a.X, a.Y, CFAGreen)-get(x, y, CFAGreen)) + abs(get(z.X, z.Y, CFAGreen)-get(x, y, CFAGreen))
+				guess[i] = get(a.X, a.Y, c) + get(z.X, z.Y, c) + 2*get(x, y, CFAGreen) -
+					get(a.X, a.Y, CFAGreen) - get(z.X, z.Y, CFAGreen)
+			}
+			var v int
+			switch {
+			case diff[0] > diff[1]:
+				v = guess[1] >> 1
+			case diff[0] < diff[1]:
+				v = guess[0] >> 1
+			default:
+				v = (guess[0] + guess[1]) >> 2
+			}
+			*img.Pix[img.offset(x, y)].channel(c) = clip16(v)
+		}
+	}
+	return img
+}
+
+//vngPair is a pair of photosites whose difference adds to the gradient of a direction.
+type vngPair struct {
+	a, b   image.Point
+	weight int
+}
+
+//vngDirection holds the pairs making up the gradient of a direction and the photosites averaged along it.
+//Weights are doubled so the half weights of the paper stay integers.
+type vngDirection struct {
+	step image.Point
+	//pairs are used for every photosite, greenPairs only for green ones and colourPairs for red and blue ones.
+	pairs       []vngPair
+	greenPairs  []vngPair
+	colourPairs []vngPair
+	//near are the photosites averaged for the colours missing at the centre, far is the fallback
+	//for a colour that doesn't occur among near.
+	near []image.Point
+	far  []image.Point
+}
+
+func rotate(p image.Point) image.Point {
+	return image.Point{-p.Y, p.X}
+}
+
+func rotatePairs(pairs []vngPair) []vngPair {
+	rotated := make([]vngPair, len(pairs))
+	for i, p := range pairs {
+		rotated[i] = vngPair{rotate(p.a), rotate(p.b), p.weight}
+	}
+	return rotated
+}
+
+func rotatePoints(points []image.Point) []image.Point {
+	rotated := make([]image.Point, len(points))
+	for i, p := range points {
+		rotated[i] = rotate(p)
+	}
+	return rotated
+}
+
+//vngDirections are north and north east from the paper, the others are rotations of those.
+var vngDirections = func() []vngDirection {
+	north := vngDirection{
+		step: image.Point{0, -1},
+		pairs: []vngPair{
+			{image.Point{0, -1}, image.Point{0, 1}, 2},
+			{image.Point{0, -2}, image.Point{0, 0}, 2},
+			{image.Point{-1, -1}, image.Point{-1, 1}, 1},
+			{image.Point{1, -1}, image.Point{1, 1}, 1},
+			{image.Point{-1, -2}, image.Point{-1, 0}, 1},
+			{image.Point{1, -2}, image.Point{1, 0}, 1},
+		},
+		near: []image.Point{{0, -1}, {-1, -1}, {1, -1}},
+		far:  []image.Point{{-1, 0}, {1, 0}, {-1, -2}, {1, -2}},
+	}
+	northEast := vngDirection{
+		step: image.Point{1, -1},
+		pairs: []vngPair{
+			{image.Point{1, -1}, image.Point{-1, 1}, 2},
+			{image.Point{2, -2}, image.Point{0, 0}, 2},
+		},
+		greenPairs: []vngPair{
+			{image.Point{1, -2}, image.Point{-1, 0}, 2},
+			{image.Point{2, -1}, image.Point{0, 1}, 2},
+		},
+		colourPairs: []vngPair{
+			{image.Point{0, -1}, image.Point{-1, 0}, 1},
+			{image.Point{1, 0}, image.Point{0, 1}, 1},
+			{image.Point{1, -2}, image.Point{0, -1}, 1},
+			{image.Point{2, -1}, image.Point{1, 0}, 1},
+		},
+		near: []image.Point{{1, -1}, {0, -1}, {1, 0}, {1, -2}, {2, -1}},
+	}
+
+	var directions []vngDirection
+	for _, d := range []vngDirection{north, northEast} {
+		for i := 0; i < 4; i++ {
+			directions = append(directions, d)
+			d = vngDirection{
+				step:        rotate(d.step),
+				pairs:       rotatePairs(d.pairs),
+				greenPairs:  rotatePairs(d.greenPairs),
+				colourPairs: rotatePairs(d.colourPairs),
+				near:        rotatePoints(d.near),
+				far:         rotatePoints(d.far),
+			}
+		}
+	}
+	return directions
+}()
+
+func (VNG) Demosaic(b *Bayer) *RGB14 {
+	img := newMosaicImage(b)
+	borderInterpolate(b, img, 2)
+
+	val := func(x, y int, p image.Point) int {
+		return int(b.Pix[b.PixOffset(x+p.X, y+p.Y)])
+	}
+
+	r := b.Rect
+	var gradients [8]int
+	for y := r.Min.Y + 2; y < r.Max.Y-2; y++ {
+		for x := r.Min.X + 2; x < r.Max.X-2; x++ {
+			own := b.ColorAt(x, y)
+
+			min, max := math.MaxInt32, 0
+			for i, d := range vngDirections {
+				g := 0
+				for _, p := range d.pairs {
+					g += p.weight * abs(val(x, y, p.a)-val(x, y, p.b))
+				}
+				extra := d.colourPairs
+				if own == CFAGreen {
+					extra = d.greenPairs
+				}
+				for _, p := range extra {
+					g += p.weight * abs(val(x, y, p.a)-val(x, y, p.b))
+				}
+				gradients[i] = g
+				if g < min {
+					min = g
+				}
+				if g > max {
+					max = g
+				}
+			}
+
+			//Only directions with a gradient below the threshold contribute.
+			threshold := min + min/2 + (max-min)/2
+			var sum [3]int
+			count := 0
+			for i, d := range vngDirections {
+				if gradients[i] > threshold {
+					continue
+				}
+				count++
+				sum[own] += (val(x, y, image.Point{}) + val(x, y, d.step.Mul(2))) / 2
+				for c := CFARed; c <= CFABlue; c++ {
+					if c == own {
+						continue
+					}
+					total, n := 0, 0
+					for _, points := range [][]image.Point{d.near, d.far} {
+						for _, p := range points {
+							if b.ColorAt(x+p.X, y+p.Y) == c {
+								total += val(x, y, p)
+								n++
+							}
+						}
+						if n > 0 {
+							break
+						}
+					}
+					if n > 0 {
+						sum[c] += total / n
+					}
+				}
+			}
+
+			px := &img.Pix[img.offset(x, y)]
+			for c := CFARed; c <= CFABlue; c++ {
+				if c != own {
+					*px.channel(c) = clip16(val(x, y, image.Point{}) + (sum[c]-sum[own])/count)
+				}
+			}
+		}
+	}
+	return img
+}
+
+//ahdTile is the size of the square tiles AHD works on, neighbouring tiles overlap by 6 pixels.
+const ahdTile = 256
+
+//cielab converts linear sRGB in the 14 bit range to CIELAB.
+func cielab(p pixel16) [3]float32 {
+	const white = 0x3fff
+	r, g, b := float64(p.R)/white, float64(p.G)/white, float64(p.B)/white
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.9505
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.089
+
+	f := func(t float64) float64 {
+		if t > 0.008856 {
+			return math.Cbrt(t)
+		}
+		return 7.787*t + 16.0/116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return [3]float32{float32(116*fy - 16), float32(500 * (fx - fy)), float32(200 * (fy - fz))}
+}
+
+func (AHD) Demosaic(b *Bayer) *RGB14 {
+	img := newMosaicImage(b)
+	borderInterpolate(b, img, 5)
+
+	get := func(x, y int, c CFAColor) int {
+		return int(*img.Pix[img.offset(x, y)].channel(c))
+	}
+
+	const ts = ahdTile
+	var rgb [2][ts * ts]pixel16
+	var lab [2][ts * ts][3]float32
+	var homo [2][ts * ts]uint8
+	r := b.Rect
+
+	for top := r.Min.Y + 2; top < r.Max.Y-5; top += ts - 6 {
+		for left := r.Min.X + 2; left < r.Max.X-5; left += ts - 6 {
+			at := func(x, y int) int { return (y-top)*ts + x - left }
+
+			//Interpolate green horizontally and vertically.
+			for y := top; y < top+ts && y < r.Max.Y-2; y++ {
+				for x := left; x < left+ts && x < r.Max.X-2; x++ {
+					c := b.ColorAt(x, y)
+					for d, step := range [2]image.Point{{1, 0}, {0, 1}} {
+						px := &rgb[d][at(x, y)]
+						*px = img.Pix[img.offset(x, y)]
+						if c == CFAGreen {
+							continue
+						}
+						n := func(k int, c CFAColor) int { return get(x+k*step.X, y+k*step.Y, c) }
+						v := ((n(-1, CFAGreen)+n(0, c)+n(1, CFAGreen))*2 - n(-2, c) - n(2, c)) >> 2
+						px.G = clip16(ulim(v, n(-1, CFAGreen), n(1, CFAGreen)))
+					}
+				}
+			}
+
+			//Interpolate red and blue from the colour differences and convert to CIELAB.
+			for d := 0; d < 2; d++ {
+				for y := top + 1; y < top+ts-1 && y < r.Max.Y-3; y++ {
+					for x := left + 1; x < left+ts-1 && x < r.Max.X-3; x++ {
+						px := &rgb[d][at(x, y)]
+						green := func(x, y int) int { return int(rgb[d][at(x, y)].G) }
+						own := b.ColorAt(x, y)
+						if own == CFAGreen {
+							for _, step := range [2]image.Point{{1, 0}, {0, 1}} {
+								c := b.ColorAt(x+step.X, y+step.Y)
+								v := int(px.G) + (get(x-step.X, y-step.Y, c)+get(x+step.X, y+step.Y, c)-
+									green(x-step.X, y-step.Y)-green(x+step.X, y+step.Y))>>1
+								*px.channel(c) = clip16(v)
+							}
+						} else {
+							c := CFABlue - own
+							v := int(px.G) + (get(x-1, y-1, c)+get(x+1, y-1, c)+get(x-1, y+1, c)+get(x+1, y+1, c)-
+								green(x-1, y-1)-green(x+1, y-1)-green(x-1, y+1)-green(x+1, y+1)+1)>>2
+							*px.channel(c) = clip16(v)
+						}
+						lab[d][at(x, y)] = cielab(*px)
+					}
+				}
+			}
+
+			//Count the neighbours whose colour is close in both interpolations.
+			homo = [2][ts * ts]uint8{}
+			neighbours := [4]int{-1, 1, -ts, ts}
+			for y := top + 2; y < top+ts-2 && y < r.Max.Y-4; y++ {
+				for x := left + 2; x < left+ts-2 && x < r.Max.X-4; x++ {
+					i := at(x, y)
+					var ldiff, abdiff [2][4]float32
+					for d := 0; d < 2; d++ {
+						l := lab[d][i]
+						for k, n := range neighbours {
+							o := lab[d][i+n]
+							ldiff[d][k] = float32(math.Abs(float64(l[0] - o[0])))
+							abdiff[d][k] = (l[1]-o[1])*(l[1]-o[1]) + (l[2]-o[2])*(l[2]-o[2])
+						}
+					}
+					leps := min32(max32(ldiff[0][0], ldiff[0][1]), max32(ldiff[1][2], ldiff[1][3]))
+					abeps := min32(max32(abdiff[0][0], abdiff[0][1]), max32(abdiff[1][2], abdiff[1][3]))
+					for d := 0; d < 2; d++ {
+						for k := range neighbours {
+							if ldiff[d][k] <= leps && abdiff[d][k] <= abeps {
+								homo[d][i]++
+							}
+						}
+					}
+				}
+			}
+
+			//Use the most homogeneous direction.
+			for y := top + 3; y < top+ts-3 && y < r.Max.Y-5; y++ {
+				for x := left + 3; x < left+ts-3 && x < r.Max.X-5; x++ {
+					i := at(x, y)
+					var hm [2]int
+					for d := 0; d < 2; d++ {
+						for dy := -1; dy <= 1; dy++ {
+							for dx := -1; dx <= 1; dx++ {
+								hm[d] += int(homo[d][i+dy*ts+dx])
+							}
+						}
+					}
+					px := &img.Pix[img.offset(x, y)]
+					switch {
+					case hm[0] > hm[1]:
+						*px = rgb[0][i]
+					case hm[1] > hm[0]:
+						*px = rgb[1][i]
+					default:
+						h, v := rgb[0][i], rgb[1][i]
+						px.R = uint16((int(h.R) + int(v.R)) >> 1)
+						px.G = uint16((int(h.G) + int(v.G)) >> 1)
+						px.B = uint16((int(h.B) + int(v.B)) >> 1)
+					}
+				}
+			}
+		}
+	}
+	return img
+}
+
+func min32(a, b float32) float32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b float32) float32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,115 @@
+package arw
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+var demosaicers = map[string]Demosaicer{
+	"bilinear": Bilinear{},
+	"PPG":      PPG{},
+	"VNG":      VNG{},
+	"AHD":      AHD{},
+}
+
+//mosaic samples a full colour image through the CFA pattern.
+func mosaic(img *RGB14, pattern [4]CFAColor) *Bayer {
+	b := &Bayer{
+		Pix:        make([]uint16, len(img.Pix)),
+		Stride:     img.Stride,
+		Rect:       img.Rect,
+		Pattern:    pattern,
+		PatternDim: [2]int{2, 2},
+		WhiteLevel: [4]uint16{0x3fff, 0x3fff, 0x3fff, 0x3fff},
+		ActiveArea: img.Rect,
+	}
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			px := img.Pix[img.offset(x, y)]
+			b.Pix[b.PixOffset(x, y)] = *px.channel(b.ColorAt(x, y))
+		}
+	}
+	return b
+}
+
+func TestDemosaicFlat(t *testing.T) {
+	flat := NewRGB14(image.Rect(0, 0, 24, 20))
+	for i := range flat.Pix {
+		flat.Pix[i] = pixel16{R: 4000, G: 8000, B: 2000}
+	}
+
+	patterns := [][4]CFAColor{
+		{CFARed, CFAGreen, CFAGreen, CFABlue},
+		{CFABlue, CFAGreen, CFAGreen, CFARed},
+		{CFAGreen, CFARed, CFABlue, CFAGreen},
+		{CFAGreen, CFABlue, CFARed, CFAGreen},
+	}
+	for name, d := range demosaicers {
+		for _, pattern := range patterns {
+			img := d.Demosaic(mosaic(flat, pattern))
+			for i, px := range img.Pix {
+				if px != flat.Pix[i] {
+					t.Fatalf("%v, pattern %v: pixel %v expected %v, got %v", name, pattern, i, flat.Pix[i], px)
+				}
+			}
+		}
+	}
+}
+
+//squaredError sums the squared difference of every channel, leaving out a border where the algorithms fall back to bilinear.
+func squaredError(a, b *RGB14, border int) int {
+	var sum int
+	r := a.Rect.Inset(border)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p, q := a.Pix[a.offset(x, y)], b.Pix[b.offset(x, y)]
+			for _, d := range []int{int(p.R) - int(q.R), int(p.G) - int(q.G), int(p.B) - int(q.B)} {
+				sum += d * d
+			}
+		}
+	}
+	return sum
+}
+
+func TestDemosaicEdges(t *testing.T) {
+	//Grey diagonal and vertical edges, nearly all of the bilinear error is colour fringing along them.
+	truth := NewRGB14(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint16(1000)
+			if x > y || x%16 >= 8 {
+				v = 12000
+			}
+			truth.Pix[truth.offset(x, y)] = pixel16{R: v, G: v, B: v}
+		}
+	}
+	b := mosaic(truth, [4]CFAColor{CFARed, CFAGreen, CFAGreen, CFABlue})
+
+	bilinear := squaredError(Bilinear{}.Demosaic(b), truth, 6)
+	for _, name := range []string{"PPG", "VNG", "AHD"} {
+		if e := squaredError(demosaicers[name].Demosaic(b), truth, 6); e >= bilinear {
+			t.Errorf("%v: expected less error than bilinear %v, got %v", name, bilinear, e)
+		}
+	}
+}
+
+func TestDecodeWithDemosaicer(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	expected, err := Decode(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, d := range demosaicers {
+		img, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{Demosaicer: d})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if img.Bounds() != expected.Bounds() {
+			t.Errorf("%v: expected bounds %v, got %v", name, expected.Bounds(), img.Bounds())
+		}
+	}
+}
@@ -1,44 +1,92 @@
 package arw
 
 import (
+	"encoding/binary"
 	"image"
 	"io"
-	"log"
+	"strings"
 )
 
 type rawDetails struct {
+	order         binary.ByteOrder
 	width         uint16
 	height        uint16
 	bitDepth      uint16
-	rawType       sonyRawFile
+	rawType       RawFormat
 	offset        uint32
 	stride        uint32
 	length        uint32
+	tileWidth     uint32
+	tileLength    uint32
+	tileOffsets   []uint32
+	tileCounts    []uint32
 	blackLevel    [4]uint16
 	WhiteBalance  [4]int16
 	gammaCurve    [5]uint16
 	crop          image.Rectangle
-	cfaPattern    [4]uint8 //TODO(sjon): This might not always be 4 bytes is my suspicion. We currently take from the offset
+	activeArea    image.Rectangle
+	orientation   ImageOrientation
+	cfaPattern    []uint8
 	cfaPatternDim [2]uint16
+	colorMatrix   [9]int16
+	whiteLevel    [3]uint16
+	wbPresets     map[WhiteBalancePreset][4]int16
 	aperture      float32
 	shutter       float32
 	iso           uint16
 	focalLength   float32
 	lensModel     string
+
+	vignettingParams []int16
+	caParams         []int16
+	distortionParams []int16
+
+	make               string
+	model              string
+	serial             string
+	dateTimeOriginal   string
+	offsetTimeOriginal string
+	subsecTimeOriginal string
+	exposureProgram    uint16
+	meteringMode       uint16
+	flash              uint16
+	focalLength35      uint16
+	lensSpec           [4]float32
 }
 
 func extractDetails(rs io.ReadSeeker) (rawDetails, error) {
 	var rw rawDetails
+	var sonyRaw bool
+	var cropOrigin, cropSize, activeArea []uint32
+
+	//Every Sony sensor so far is RGGB, files without a CFA pattern are read as such.
+	rw.cfaPattern = []uint8{0, 1, 1, 2}
+	rw.cfaPatternDim = [2]uint16{2, 2}
+	rw.orientation = OrientationTopLeft
 
 	header, err := ParseHeader(rs)
-	meta, err := ExtractMetaData(rs, int64(header.Offset), 0)
 	if err != nil {
 		return rw, err
 	}
+	rw.order = header.Order()
+
+	meta, err := ExtractMetaDataWithOrder(rs, rw.order, int64(header.Offset), 0)
+	if err != nil {
+		return rw, err
+	}
+
+	for n, fia := range meta.FIA {
+		switch fia.Tag {
+		case Make:
+			rw.make = ascii(meta.FIAvals[n])
+		case Model:
+			rw.model = ascii(meta.FIAvals[n])
+		case Orientation:
+			rw.orientation = orientation(uint16(first(meta.FIAvals[n])))
+		}
 
-	for _, fia := range meta.FIA {
 		if fia.Tag == SubIFDs {
-			rawIFD, err := ExtractMetaData(rs, int64(fia.Offset), 0)
+			rawIFD, err := rawSubIFD(rs, rw.order, longs(meta.FIAvals[n]))
 			if err != nil {
 				return rw, err
 			}
@@ -46,66 +94,120 @@ func extractDetails(rs io.ReadSeeker) (rawDetails, error) {
 			for i, v := range rawIFD.FIA {
 				switch v.Tag {
 				case ImageWidth:
-					rw.width = uint16(v.Offset)
+					rw.width = uint16(first(rawIFD.FIAvals[i]))
 				case ImageHeight:
-					rw.height = uint16(v.Offset)
+					rw.height = uint16(first(rawIFD.FIAvals[i]))
 				case BitsPerSample:
-					rw.bitDepth = uint16(v.Offset)
+					rw.bitDepth = uint16(first(rawIFD.FIAvals[i]))
 				case SonyRawFileType:
-					rw.rawType = sonyRawFile(v.Offset)
+					rw.rawType = RawFormat(first(rawIFD.FIAvals[i]))
+					sonyRaw = true
 				case StripOffsets:
-					rw.offset = v.Offset
+					rw.offset = first(rawIFD.FIAvals[i])
 				case RowsPerStrip:
-					rw.stride = v.Offset //TODO(sjon): Uncompressed RAW files are 2 bytes per pixel whereas CRAW is 1 byte per pixel, this shouldn't be set here! current behaviour is for CRAW, add a divide by 2 for RAW
+					rw.stride = first(rawIFD.FIAvals[i]) //TODO(sjon): Uncompressed RAW files are 2 bytes per pixel whereas CRAW is 1 byte per pixel, this shouldn't be set here! current behaviour is for CRAW, add a divide by 2 for RAW
 				case StripByteCounts:
-					rw.length = v.Offset
+					rw.length = first(rawIFD.FIAvals[i])
+				case TileWidth:
+					rw.tileWidth = first(rawIFD.FIAvals[i])
+				case TileLength:
+					rw.tileLength = first(rawIFD.FIAvals[i])
+				case TileOffsets:
+					rw.tileOffsets = longs(rawIFD.FIAvals[i])
+				case TileByteCounts:
+					rw.tileCounts = longs(rawIFD.FIAvals[i])
 				case SonyCurve:
-					curve := *rawIFD.FIAvals[i].short
-					copy(rw.gammaCurve[:4], curve)
-					rw.gammaCurve[4] = 0x3fff
+					copy(rw.gammaCurve[:4], shorts(rawIFD.FIAvals[i]))
+					rw.gammaCurve[4] = 0x3fff //The end of the 14 bit range the curve is defined over, not the white level of the sensor.
 				case BlackLevel2:
-					black := *rawIFD.FIAvals[i].short
-					copy(rw.blackLevel[:], black)
+					copy(rw.blackLevel[:], shorts(rawIFD.FIAvals[i]))
 				case WB_RGGBLevels:
-					balance := *rawIFD.FIAvals[i].sshort
-					copy(rw.WhiteBalance[:], balance)
+					copy(rw.WhiteBalance[:], sshorts(rawIFD.FIAvals[i]))
+				case DefaultCropOrigin:
+					cropOrigin = longs(rawIFD.FIAvals[i])
 				case DefaultCropSize:
+					cropSize = longs(rawIFD.FIAvals[i])
+				case ActiveArea:
+					activeArea = longs(rawIFD.FIAvals[i])
 				case CFAPattern2:
-					rw.cfaPattern[0] = uint8((v.Offset & 0x000000ff) >> 0)
-					rw.cfaPattern[1] = uint8((v.Offset & 0x0000ff00) >> 8)
-					rw.cfaPattern[2] = uint8((v.Offset & 0x00ff0000) >> 16)
-					rw.cfaPattern[3] = uint8((v.Offset & 0xff000000) >> 24)
+					if pattern := rawIFD.FIAvals[i].ascii; pattern != nil {
+						rw.cfaPattern = *pattern
+					}
 				case CFARepeatPatternDim:
-					rw.cfaPatternDim[0] = uint16((v.Offset & 0x0000ffff) >> 0)
-					rw.cfaPatternDim[1] = uint16((v.Offset & 0xffff0000) >> 16)
+					copy(rw.cfaPatternDim[:], shorts(rawIFD.FIAvals[i]))
+				case ColorMatrix:
+					copy(rw.colorMatrix[:], sshorts(rawIFD.FIAvals[i]))
+				case WhiteLevel:
+					levels := shorts(rawIFD.FIAvals[i])
+					if len(levels) == 1 {
+						levels = []uint16{levels[0], levels[0], levels[0]}
+					}
+					copy(rw.whiteLevel[:], levels)
+				case VignettingCorrParams:
+					rw.vignettingParams = sshorts(rawIFD.FIAvals[i])
+				case ChromaticAberrationCorrParams:
+					rw.caParams = sshorts(rawIFD.FIAvals[i])
+				case DistortionCorrParams:
+					rw.distortionParams = sshorts(rawIFD.FIAvals[i])
+				default:
+					preset, ok := presetTags[v.Tag]
+					if !ok {
+						break
+					}
+					if levels, ok := presetLevels(shorts(rawIFD.FIAvals[i])); ok {
+						if rw.wbPresets == nil {
+							rw.wbPresets = make(map[WhiteBalancePreset][4]int16)
+						}
+						rw.wbPresets[preset] = levels
+					}
 				}
 			}
 		}
 
 		if fia.Tag == ExifTag {
-			exif, err := ExtractMetaData(rs, int64(fia.Offset), 0)
+			exif, err := ExtractMetaDataWithOrder(rs, rw.order, int64(fia.Offset), 0)
 			if err != nil {
 				return rw, err
 			}
 			for i, v := range exif.FIA {
 				switch v.Tag {
 				case ExposureTime:
-					rw.shutter = (*exif.FIAvals[i].rat)[0]
+					rw.shutter = rational(exif.FIAvals[i])
 				case FNumber:
-					rw.aperture = (*exif.FIAvals[i].rat)[0]
+					rw.aperture = rational(exif.FIAvals[i])
 				case ISOSpeedRatings:
-					rw.iso = uint16((v.Offset & 0x0000ffff) >> 0)
+					rw.iso = uint16(first(exif.FIAvals[i]))
 				case FocalLength:
-					rw.focalLength = (*exif.FIAvals[i].rat)[0]
+					rw.focalLength = rational(exif.FIAvals[i])
 				case LensModel:
-					rw.lensModel = string(*exif.FIAvals[i].ascii)
+					rw.lensModel = ascii(exif.FIAvals[i])
+				case LensSpecification:
+					if exif.FIAvals[i].rat != nil {
+						copy(rw.lensSpec[:], *exif.FIAvals[i].rat)
+					}
+				case BodySerialNumber:
+					rw.serial = ascii(exif.FIAvals[i])
+				case DateTimeOriginal:
+					rw.dateTimeOriginal = ascii(exif.FIAvals[i])
+				case OffsetTimeOriginal:
+					rw.offsetTimeOriginal = ascii(exif.FIAvals[i])
+				case SubsecTimeOriginal:
+					rw.subsecTimeOriginal = ascii(exif.FIAvals[i])
+				case ExposureProgram:
+					rw.exposureProgram = uint16(first(exif.FIAvals[i]))
+				case MeteringMode:
+					rw.meteringMode = uint16(first(exif.FIAvals[i]))
+				case Flash:
+					rw.flash = uint16(first(exif.FIAvals[i]))
+				case FocalLengthIn35mmFilm:
+					rw.focalLength35 = uint16(first(exif.FIAvals[i]))
 				}
 			}
 
 		}
 
 		//if fia.Tag == DNGPrivateData {
-		//	dng, err := ExtractMetaData(rs, int64(fia.Offset), 0)
+		//	dng, err := ExtractMetaDataWithOrder(rs, rw.order, int64(fia.Offset), 0)
 		//	if err != nil {
 		//		return rw, err
 		//	}
@@ -131,10 +233,13 @@ func extractDetails(rs io.ReadSeeker) (rawDetails, error) {
 		//		}
 		//	}
 		//
-		//	buf := DecryptSR2(rs, sr2offset, sr2length)
+		//	buf, err := DecryptSR2(rs, sr2offset, sr2length)
+		//	if err != nil {
+		//		return rw, err
+		//	}
 		//	br := bytes.NewReader(buf)
 		//
-		//	sr2, err := ExtractMetaData(br, 0, 0)
+		//	sr2, err := ExtractMetaDataWithOrder(br, rw.order, 0, 0)
 		//	if err != nil {
 		//		log.Fatal(err)
 		//	}
@@ -152,6 +257,160 @@ func extractDetails(rs io.ReadSeeker) (rawDetails, error) {
 		//}
 	}
 
-	log.Printf("%+v\n", rw)
-	return rw, nil
+	if !sonyRaw {
+		return rw, ErrNotARW
+	}
+	rw.setCrop(cropOrigin, cropSize, activeArea)
+
+	size, err := rs.Seek(0, 2)
+	if err != nil {
+		return rw, err
+	}
+	return rw, rw.checkBounds(size)
+}
+
+//rawSubIFD returns the SubIFD holding the raw data, some cameras store a full size JPEG in another SubIFD.
+//Without a SonyRawFileType tag in any of them the first is returned.
+func rawSubIFD(rs io.ReadSeeker, order binary.ByteOrder, offsets []uint32) (EXIFIFD, error) {
+	var first EXIFIFD
+	for i, offset := range offsets {
+		ifd, err := ExtractMetaDataWithOrder(rs, order, int64(offset), 0)
+		if err != nil {
+			return ifd, err
+		}
+		if hasTag(ifd, SonyRawFileType) {
+			return ifd, nil
+		}
+		if i == 0 {
+			first = ifd
+		}
+	}
+	return first, nil
+}
+
+func hasTag(ifd EXIFIFD, tag IFDtag) bool {
+	for _, fia := range ifd.FIA {
+		if fia.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+//setCrop works out the active area and the default crop within it from the DNG style tags,
+//without them or when they don't fit the image the whole image is used.
+func (rw *rawDetails) setCrop(origin, size, active []uint32) {
+	full := image.Rect(0, 0, int(rw.width), int(rw.height))
+	rw.activeArea = full
+	if len(active) == 4 {
+		//ActiveArea is top, left, bottom, right.
+		area := image.Rect(int(active[1]), int(active[0]), int(active[3]), int(active[2]))
+		if area.In(full) && !area.Empty() {
+			rw.activeArea = area
+		}
+	}
+
+	rw.crop = rw.activeArea
+	if len(size) == 2 {
+		var min image.Point
+		if len(origin) == 2 {
+			min = image.Pt(int(origin[0]), int(origin[1]))
+		}
+		//The crop origin is relative to the active area.
+		crop := image.Rectangle{min, min.Add(image.Pt(int(size[0]), int(size[1])))}.Add(rw.activeArea.Min)
+		if crop.In(rw.activeArea) && !crop.Empty() {
+			rw.crop = crop
+		}
+	}
+}
+
+//maxPixels limits the size of the raw image, the largest Sony sensors are a little over 60 megapixels.
+const maxPixels = 1 << 28
+
+//checkBounds verifies the raw data lies within the file and the dimensions are sane,
+//so nothing large gets allocated based on a corrupt or hostile file.
+func (rw rawDetails) checkBounds(size int64) error {
+	if rw.width == 0 || rw.height == 0 {
+		return ErrCorrupt
+	}
+	if int(rw.width)*int(rw.height) > maxPixels {
+		return ErrValueOutOfRange
+	}
+	if int64(rw.offset)+int64(rw.length) > size {
+		return ErrValueOutOfRange
+	}
+	if len(rw.tileOffsets) != len(rw.tileCounts) {
+		return ErrBadIFD
+	}
+	for i := range rw.tileOffsets {
+		if int64(rw.tileOffsets[i])+int64(rw.tileCounts[i]) > size {
+			return ErrValueOutOfRange
+		}
+	}
+	return nil
+}
+
+//longs returns the values of a SHORT or LONG field.
+func longs(val FIAval) []uint32 {
+	switch {
+	case val.long != nil:
+		return *val.long
+	case val.short != nil:
+		values := make([]uint32, len(*val.short))
+		for i, v := range *val.short {
+			values[i] = uint32(v)
+		}
+		return values
+	}
+	return nil
+}
+
+//first returns the first value of a SHORT or LONG field, zero when it has none.
+//A SHORT can't be taken from the offset field as is, in big endian files it sits in the high half.
+func first(val FIAval) uint32 {
+	if values := longs(val); len(values) > 0 {
+		return values[0]
+	}
+	return 0
+}
+
+//shorts returns the values of a SHORT or SSHORT field.
+func shorts(val FIAval) []uint16 {
+	switch {
+	case val.short != nil:
+		return *val.short
+	case val.sshort != nil:
+		values := make([]uint16, len(*val.sshort))
+		for i, v := range *val.sshort {
+			values[i] = uint16(v)
+		}
+		return values
+	}
+	return nil
+}
+
+//sshorts returns the values of a SHORT or SSHORT field as signed values.
+func sshorts(val FIAval) []int16 {
+	values := shorts(val)
+	signed := make([]int16, len(values))
+	for i, v := range values {
+		signed[i] = int16(v)
+	}
+	return signed
+}
+
+//rational returns the first value of a RATIONAL or SRATIONAL field.
+func rational(val FIAval) float32 {
+	if val.rat == nil || len(*val.rat) == 0 {
+		return 0
+	}
+	return (*val.rat)[0]
+}
+
+//ascii returns the value of an ASCII field without the terminating NUL.
+func ascii(val FIAval) string {
+	if val.ascii == nil {
+		return ""
+	}
+	return strings.TrimRight(string(*val.ascii), "\x00 ")
 }
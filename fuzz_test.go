@@ -0,0 +1,106 @@
+package arw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+//fuzzSeeds returns a small file of every raw type.
+func fuzzSeeds() [][]byte {
+	const width, height = 32, 4
+
+	mosaic := make([]uint16, width*height)
+	for i := range mosaic {
+		mosaic[i] = uint16(i*131) & 0x3fff
+	}
+	file := arwtest.File{
+		Width:        width,
+		Height:       height,
+		Pixels:       mosaic,
+		Curve:        [4]uint16{0x2000, 0x2800, 0x3000, 0x3800},
+		BlackLevel:   [4]uint16{512, 512, 512, 512},
+		WhiteBalance: [4]int16{2000, 1024, 1024, 1500},
+	}
+
+	var seeds [][]byte
+	for _, format := range []arwtest.Format{arwtest.Raw14, arwtest.Raw12, arwtest.CRAW, arwtest.CRAWLossless} {
+		file.Format = format
+		seeds = append(seeds, file.Bytes())
+	}
+	return seeds
+}
+
+func FuzzParseIFD(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Add(tinyTIFF(binary.BigEndian, 6000, 4000))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		header, err := ParseHeader(r)
+		if err != nil {
+			return
+		}
+		ExtractMetaDataWithOrder(r, header.Order(), int64(header.Offset), 0)
+		extractDetails(bytes.NewReader(data))
+	})
+}
+
+func FuzzCrawBlock(f *testing.F) {
+	craw := fuzzSeeds()[2]
+	f.Add(make([]byte, pixelBlockSize))
+	f.Add(craw[len(craw)-pixelBlockSize:])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < pixelBlockSize {
+			return
+		}
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			readCrawBlock(data[:pixelBlockSize], order).Decompress()
+		}
+	})
+}
+
+func FuzzDecryptSR2(f *testing.F) {
+	f.Add(make([]byte, 64), uint32(0), uint32(64))
+	f.Add(make([]byte, 64), uint32(60), uint32(16))
+
+	f.Fuzz(func(t *testing.T, data []byte, offset, length uint32) {
+		buf, err := DecryptSR2(bytes.NewReader(data), offset, length)
+		if err == nil && len(buf) != int(length) {
+			t.Errorf("Expected %v bytes, got %v", length, len(buf))
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		img, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if b := img.Bounds(); b.Dx() != cfg.Width || b.Dy() != cfg.Height {
+			t.Errorf("Decode returned %v, DecodeConfig %vx%v", b, cfg.Width, cfg.Height)
+		}
+	})
+}
+
+func TestFuzzSeedsDecode(t *testing.T) {
+	for _, seed := range fuzzSeeds() {
+		if _, err := Decode(bytes.NewReader(seed)); err != nil {
+			t.Error(err)
+		}
+	}
+}
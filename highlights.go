@@ -0,0 +1,172 @@
+package arw
+
+//HighlightMode is how highlights are developed where the white balance has pushed a channel past white.
+//go:generate stringer -type=HighlightMode
+type HighlightMode uint8
+
+const (
+	//HighlightClip clips every channel at white, where only some channels clipped the highlight takes on a colour cast.
+	HighlightClip HighlightMode = iota
+	//HighlightBlend clips like HighlightClip and then fades the highlight to neutral the further its brightest channel went past white.
+	HighlightBlend
+	//HighlightReconstruct estimates the clipped channels from the colour of unclipped neighbours and brings the result back within white
+	//keeping its hue. Where no neighbour is unclipped it falls back to HighlightBlend.
+	HighlightReconstruct
+)
+
+//reconstructRadius is how far HighlightReconstruct looks for unclipped neighbours.
+const reconstructRadius = 4
+
+//apply brings every channel of img within 14 bit white. b is the balanced mosaic img was demosaiced from,
+//its white levels are where every channel saturated on the sensor.
+func (m HighlightMode) apply(img *RGB14, b *Bayer) {
+	var saturation [3]float64
+	for i, c := range b.Pattern {
+		if v := float64(b.WhiteLevel[i]); v > saturation[c] {
+			saturation[c] = v
+		}
+	}
+
+	switch m {
+	case HighlightBlend:
+		blendHighlights(img, saturation)
+	case HighlightReconstruct:
+		reconstructHighlights(img, saturation)
+	default:
+		for i := range img.Pix {
+			px := &img.Pix[i]
+			px.R, px.G, px.B = clipWhite(px.R), clipWhite(px.G), clipWhite(px.B)
+		}
+	}
+}
+
+func clipWhite(v uint16) uint16 {
+	if v > 0x3fff {
+		return 0x3fff
+	}
+	return v
+}
+
+//blendHighlights clips every pixel and moves it towards white by how far its brightest channel went past white,
+//relative to the furthest any channel can go. Pixels where every channel saturated end up white.
+func blendHighlights(img *RGB14, saturation [3]float64) {
+	headroom := max3(saturation) - 0x3fff
+	for i := range img.Pix {
+		blendPixel(&img.Pix[i], headroom)
+	}
+}
+
+func blendPixel(px *pixel16, headroom float64) {
+	over := float64(max16(px.R, px.G, px.B)) - 0x3fff
+	px.R, px.G, px.B = clipWhite(px.R), clipWhite(px.G), clipWhite(px.B)
+	if over <= 0 {
+		return
+	}
+	t := 1.0
+	if headroom > 0 && over < headroom {
+		t = over / headroom
+	}
+	for _, c := range []*uint16{&px.R, &px.G, &px.B} {
+		*c += uint16(t*float64(0x3fff-*c) + 0.5)
+	}
+}
+
+//reconstructHighlights replaces every clipped channel by the value its unclipped channels predict from the colour of
+//nearby pixels where nothing clipped, the clipped value is a lower bound for the estimate.
+//Pixels past white are then scaled down as a whole so they keep their hue.
+func reconstructHighlights(img *RGB14, saturation [3]float64) {
+	clipped := func(px pixel16, c CFAColor) bool {
+		//Interpolation softens the edge of a clipped area, so a channel close to saturation counts as clipped.
+		return float64(*px.channel(c)) >= saturation[c]*0.98
+	}
+	anyClipped := func(px pixel16) bool {
+		return clipped(px, CFARed) || clipped(px, CFAGreen) || clipped(px, CFABlue)
+	}
+
+	headroom := max3(saturation) - 0x3fff
+	out := make([]pixel16, len(img.Pix))
+	copy(out, img.Pix)
+	r := img.Rect
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			px := img.Pix[img.offset(x, y)]
+			if !anyClipped(px) {
+				continue
+			}
+
+			//The brightest unclipped channel is the most reliable reference.
+			ref, found := CFARed, false
+			for _, c := range []CFAColor{CFARed, CFAGreen, CFABlue} {
+				if !clipped(px, c) && (!found || *px.channel(c) > *px.channel(ref)) {
+					ref, found = c, true
+				}
+			}
+			est := &out[img.offset(x, y)]
+			if !found || *px.channel(ref) == 0 {
+				blendPixel(est, headroom)
+				continue
+			}
+
+			var ratio [3]float64
+			var n int
+			for ny := y - reconstructRadius; ny <= y+reconstructRadius; ny++ {
+				for nx := x - reconstructRadius; nx <= x+reconstructRadius; nx++ {
+					if nx < r.Min.X || nx >= r.Max.X || ny < r.Min.Y || ny >= r.Max.Y {
+						continue
+					}
+					q := img.Pix[img.offset(nx, ny)]
+					if anyClipped(q) || *q.channel(ref) == 0 {
+						continue
+					}
+					for c := range ratio {
+						ratio[c] += float64(*q.channel(CFAColor(c))) / float64(*q.channel(ref))
+					}
+					n++
+				}
+			}
+			if n == 0 {
+				blendPixel(est, headroom)
+				continue
+			}
+
+			for _, c := range []CFAColor{CFARed, CFAGreen, CFABlue} {
+				if !clipped(px, c) {
+					continue
+				}
+				guess := float64(*px.channel(ref)) * ratio[c] / float64(n)
+				if guess > float64(*px.channel(c)) {
+					*est.channel(c) = clip16(int(guess + 0.5))
+				}
+			}
+		}
+	}
+
+	for i := range out {
+		px := &out[i]
+		if top := max16(px.R, px.G, px.B); top > 0x3fff {
+			scale := float64(0x3fff) / float64(top)
+			px.R, px.G, px.B = uint16(float64(px.R)*scale+0.5), uint16(float64(px.G)*scale+0.5), uint16(float64(px.B)*scale+0.5)
+		}
+	}
+	img.Pix = out
+}
+
+func max3(v [3]float64) float64 {
+	m := v[0]
+	for _, x := range v[1:] {
+		if x > m {
+			m = x
+		}
+	}
+	return m
+}
+
+func max16(a, b, c uint16) uint16 {
+	if b > a {
+		a = b
+	}
+	if c > a {
+		a = c
+	}
+	return a
+}
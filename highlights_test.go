@@ -0,0 +1,77 @@
+package arw
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+//highlightScene is a 9x9 patch coloured 2:1:1.5 with a single pixel in the middle where green clipped, the white balance
+//lets red and blue go four times as far past white as green.
+func highlightScene(centre pixel16) (*RGB14, *Bayer) {
+	img := NewRGB14(image.Rect(0, 0, 9, 9))
+	for i := range img.Pix {
+		img.Pix[i] = pixel16{R: 0x2000, G: 0x1000, B: 0x1800}
+	}
+	img.Pix[img.offset(4, 4)] = centre
+
+	b := &Bayer{
+		Pattern:    [4]CFAColor{CFARed, CFAGreen, CFAGreen, CFABlue},
+		PatternDim: [2]int{2, 2},
+		WhiteLevel: [4]uint16{0xfffc, 0x3fff, 0x3fff, 0xfffc},
+	}
+	return img, b
+}
+
+func TestHighlightModes(t *testing.T) {
+	clippedGreen := pixel16{R: 0xa000, G: 0x3fff, B: 0x7800}
+	saturated := pixel16{R: 0xfffc, G: 0x3fff, B: 0xfffc}
+
+	expected := []struct {
+		mode     HighlightMode
+		centre   pixel16
+		expected pixel16
+	}{
+		{HighlightClip, clippedGreen, pixel16{R: 0x3fff, G: 0x3fff, B: 0x3fff}},
+		{HighlightClip, saturated, pixel16{R: 0x3fff, G: 0x3fff, B: 0x3fff}},
+		//Red went a quarter of the way in to the headroom.
+		{HighlightBlend, pixel16{R: 0x3fff + 0x2fff, G: 0x1000, B: 0x1800}, pixel16{R: 0x3fff, G: 0x1c00, B: 0x2200}},
+		{HighlightBlend, saturated, pixel16{R: 0x3fff, G: 0x3fff, B: 0x3fff}},
+		//Green comes back at half of red and the pixel is scaled back within white.
+		{HighlightReconstruct, clippedGreen, pixel16{R: 0x3fff, G: 0x2000, B: 0x2fff}},
+		{HighlightReconstruct, saturated, pixel16{R: 0x3fff, G: 0x3fff, B: 0x3fff}},
+	}
+	for _, e := range expected {
+		img, b := highlightScene(e.centre)
+		e.mode.apply(img, b)
+
+		if px := img.Pix[img.offset(4, 4)]; px != e.expected {
+			t.Errorf("%v: expected %v to become %v, got %v", e.mode, e.centre, e.expected, px)
+		}
+		if px := img.Pix[img.offset(3, 3)]; px != (pixel16{R: 0x2000, G: 0x1000, B: 0x1800}) {
+			t.Errorf("%v: expected unclipped pixels to be left alone, got %v", e.mode, px)
+		}
+	}
+}
+
+func TestDecodeSaturatedHighlights(t *testing.T) {
+	//Every photosite saturated, which has to develop to white however strong the white balance is.
+	file := syntheticFile(arwtest.Raw14)
+	for i := range file.Pixels {
+		file.Pixels[i] = 0x3fff
+	}
+
+	for _, mode := range []HighlightMode{HighlightClip, HighlightBlend, HighlightReconstruct} {
+		img, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{Highlights: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, px := range img.(*RGB14).Pix {
+			if px != (pixel16{R: 0x3fff, G: 0x3fff, B: 0x3fff}) {
+				t.Fatalf("%v: pixel %v expected white, got %v", mode, i, px)
+			}
+		}
+	}
+}
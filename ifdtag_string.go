@@ -4,7 +4,7 @@ package arw
 
 import "fmt"
 
-const _IFDtag_name = "NewSubFileTypeImageWidthImageHeightBitsPerSampleCompressionPhotometricInterpretationImageDescriptionMakeModelStripOffsetsOrientationSamplesPerPixelRowsPerStripStripByteCountsXResolutionYResolutionPlanarConfigurationResolutionUnitSoftwareDateTimeWhitepointPrimaryChromaticitiesSubIFDsJPEGInterchangeFormatJPEGInterchangeFormatLengthYCbCrCoefficientsYCbCrPositioningXMPShotInfoSonyRawFileTypeSonyCurveSR2SubIFDOffsetSR2SubIFDLengthSR2SubIFDKeyIDC_IFDIDC2_IFDMRWInfoBlackLevelWB_GRBGLevelsAutoWB_GRBGLevelsBlackLevel2WB_RGGBLevelsWB_RGBLevelsDaylightWB_RGBLevelsCloudyWB_RGBLevelsTungstenWB_RGBLevelsFlashWB_RGBLevels4500KWB_RGBLevelsFluorescentMaxApertureAtMaxFocalMaxApertureAtMinFocalMaxFocalLengthMinFocalLengthSR2DataIFDColorMatrixWB_RGBLevelsDaylight2WB_RGBLevelsCloudy2WB_RGBLevelsTungsten2WB_RGBLevelsFlash2WB_RGBLevels4500K2WB_RGBLevelsShade2WB_RGBLevelsFluorescent2WB_RGBLevelsFluorescentP1WB_RGBLevelsFluorescentP2WB_RGBLevelsFluorescentM1WB_RGBLevels8500KWB_RGBLevels6000KWB_RGBLevels3200KWB_RGBLevels2500KWhiteLevelVignettingCorrParamsChromaticAberrationCorrParamsDistortionCorrParamsCFARepeatPatternDimCFAPattern2ExposureTimeFNumberExifTagExposureProgramSpectralSensitivityGPSTagISOSpeedRatingsOECFSensitivityTypeRecommendedExposureIndexExifVersionDateTimeOriginalDateTimeDigitizedOffsetTimeOffsetTimeOriginalOffsetTimeDigitizedComponentsConfigurationCompressedBitsPerPixelShutterSpeedValueApertureValueBrightnessValueExposureBiasValueMaxApertureValueSubjectDistanceMeteringModeLightSourceFlashFocalLengthSubjectAreaMakerNoteUserCommentSubsecTimeSubsecTimeOriginalSubsecTimeDigitizedTag9400FlashpixVersionColorSpacePixelXDimensionPixelYDimensionRelatedSoundFileInteroperabilityTagFlashEnergySpatialFrequencyResponseFocalPlaneXResolutionFocalPlaneYResolutionFocalPlaneResolutionUnitSubjectLocationExposureIndexSensingMethodFileSourceSceneTypeCFAPatternCustomRenderedExposureModeWhiteBalanceDigitalZoomRatioFocalLengthIn35mmFilmSceneCaptureTypeGainControlContrastSaturationSharpnessDeviceSettingDescriptionSubjectDistanceRangeImageUniqueIDLensSpecificationLensModelGammaFileFormatSonyModelIDCreativeStyleLensSpecFullImageSizePreviewImageSizePrintImageMatchingDefaultCropOriginDefaultCropSizeDNGPrivateData"
+const _IFDtag_name = "NewSubFileTypeImageWidthImageHeightBitsPerSampleCompressionPhotometricInterpretationImageDescriptionMakeModelStripOffsetsOrientationSamplesPerPixelRowsPerStripStripByteCountsXResolutionYResolutionPlanarConfigurationResolutionUnitSoftwareDateTimeWhitepointPrimaryChromaticitiesTileWidthTileLengthTileOffsetsTileByteCountsSubIFDsJPEGInterchangeFormatJPEGInterchangeFormatLengthYCbCrCoefficientsYCbCrPositioningXMPShotInfoSonyRawFileTypeSonyCurveSR2SubIFDOffsetSR2SubIFDLengthSR2SubIFDKeyIDC_IFDIDC2_IFDMRWInfoBlackLevelWB_GRBGLevelsAutoWB_GRBGLevelsBlackLevel2WB_RGGBLevelsWB_RGBLevelsDaylightWB_RGBLevelsCloudyWB_RGBLevelsTungstenWB_RGBLevelsFlashWB_RGBLevels4500KWB_RGBLevelsFluorescentMaxApertureAtMaxFocalMaxApertureAtMinFocalMaxFocalLengthMinFocalLengthSR2DataIFDColorMatrixWB_RGBLevelsDaylight2WB_RGBLevelsCloudy2WB_RGBLevelsTungsten2WB_RGBLevelsFlash2WB_RGBLevels4500K2WB_RGBLevelsShade2WB_RGBLevelsFluorescent2WB_RGBLevelsFluorescentP1WB_RGBLevelsFluorescentP2WB_RGBLevelsFluorescentM1WB_RGBLevels8500KWB_RGBLevels6000KWB_RGBLevels3200KWB_RGBLevels2500KWhiteLevelVignettingCorrParamsChromaticAberrationCorrParamsDistortionCorrParamsCFARepeatPatternDimCFAPattern2ExposureTimeFNumberExifTagInterColorProfileExposureProgramSpectralSensitivityGPSTagISOSpeedRatingsOECFSensitivityTypeRecommendedExposureIndexExifVersionDateTimeOriginalDateTimeDigitizedOffsetTimeOffsetTimeOriginalOffsetTimeDigitizedComponentsConfigurationCompressedBitsPerPixelShutterSpeedValueApertureValueBrightnessValueExposureBiasValueMaxApertureValueSubjectDistanceMeteringModeLightSourceFlashFocalLengthSubjectAreaMakerNoteUserCommentSubsecTimeSubsecTimeOriginalSubsecTimeDigitizedTag9400FlashpixVersionColorSpacePixelXDimensionPixelYDimensionRelatedSoundFileInteroperabilityTagFlashEnergySpatialFrequencyResponseFocalPlaneXResolutionFocalPlaneYResolutionFocalPlaneResolutionUnitSubjectLocationExposureIndexSensingMethodFileSourceSceneTypeCFAPatternCustomRenderedExposureModeWhiteBalanceDigitalZoomRatioFocalLengthIn35mmFilmSceneCaptureTypeGainControlContrastSaturationSharpnessDeviceSettingDescriptionSubjectDistanceRangeImageUniqueIDBodySerialNumberLensSpecificationLensModelGammaFileFormatSonyModelIDCreativeStyleLensSpecFullImageSizePreviewImageSizePrintImageMatchingDefaultCropOriginDefaultCropSizeDNGPrivateDataActiveArea"
 
 var _IFDtag_map = map[IFDtag]string{
 	254:   _IFDtag_name[0:14],
@@ -29,136 +29,143 @@ var _IFDtag_map = map[IFDtag]string{
 	306:   _IFDtag_name[237:245],
 	318:   _IFDtag_name[245:255],
 	319:   _IFDtag_name[255:276],
-	330:   _IFDtag_name[276:283],
-	513:   _IFDtag_name[283:304],
-	514:   _IFDtag_name[304:331],
-	529:   _IFDtag_name[331:348],
-	531:   _IFDtag_name[348:364],
-	700:   _IFDtag_name[364:367],
-	12288: _IFDtag_name[367:375],
-	28672: _IFDtag_name[375:390],
-	28688: _IFDtag_name[390:399],
-	29184: _IFDtag_name[399:414],
-	29185: _IFDtag_name[414:429],
-	29217: _IFDtag_name[429:441],
-	29248: _IFDtag_name[441:448],
-	29249: _IFDtag_name[448:456],
-	29264: _IFDtag_name[456:463],
-	29440: _IFDtag_name[463:473],
-	29442: _IFDtag_name[473:490],
-	29443: _IFDtag_name[490:503],
-	29456: _IFDtag_name[503:514],
-	29459: _IFDtag_name[514:527],
-	29824: _IFDtag_name[527:547],
-	29825: _IFDtag_name[547:565],
-	29826: _IFDtag_name[565:585],
-	29827: _IFDtag_name[585:602],
-	29828: _IFDtag_name[602:619],
-	29830: _IFDtag_name[619:642],
-	29856: _IFDtag_name[642:663],
-	29857: _IFDtag_name[663:684],
-	29858: _IFDtag_name[684:698],
-	29859: _IFDtag_name[698:712],
-	29888: _IFDtag_name[712:722],
-	30720: _IFDtag_name[722:733],
-	30752: _IFDtag_name[733:754],
-	30753: _IFDtag_name[754:773],
-	30754: _IFDtag_name[773:794],
-	30755: _IFDtag_name[794:812],
-	30756: _IFDtag_name[812:830],
-	30757: _IFDtag_name[830:848],
-	30758: _IFDtag_name[848:872],
-	30759: _IFDtag_name[872:897],
-	30760: _IFDtag_name[897:922],
-	30761: _IFDtag_name[922:947],
-	30762: _IFDtag_name[947:964],
-	30763: _IFDtag_name[964:981],
-	30764: _IFDtag_name[981:998],
-	30765: _IFDtag_name[998:1015],
-	30847: _IFDtag_name[1015:1025],
-	31101: _IFDtag_name[1025:1045],
-	31104: _IFDtag_name[1045:1074],
-	31106: _IFDtag_name[1074:1094],
-	33421: _IFDtag_name[1094:1113],
-	33422: _IFDtag_name[1113:1124],
-	33434: _IFDtag_name[1124:1136],
-	33437: _IFDtag_name[1136:1143],
-	34665: _IFDtag_name[1143:1150],
-	34850: _IFDtag_name[1150:1165],
-	34852: _IFDtag_name[1165:1184],
-	34853: _IFDtag_name[1184:1190],
-	34855: _IFDtag_name[1190:1205],
-	34856: _IFDtag_name[1205:1209],
-	34864: _IFDtag_name[1209:1224],
-	34866: _IFDtag_name[1224:1248],
-	36864: _IFDtag_name[1248:1259],
-	36867: _IFDtag_name[1259:1275],
-	36868: _IFDtag_name[1275:1292],
-	36880: _IFDtag_name[1292:1302],
-	36881: _IFDtag_name[1302:1320],
-	36882: _IFDtag_name[1320:1339],
-	37121: _IFDtag_name[1339:1362],
-	37122: _IFDtag_name[1362:1384],
-	37377: _IFDtag_name[1384:1401],
-	37378: _IFDtag_name[1401:1414],
-	37379: _IFDtag_name[1414:1429],
-	37380: _IFDtag_name[1429:1446],
-	37381: _IFDtag_name[1446:1462],
-	37382: _IFDtag_name[1462:1477],
-	37383: _IFDtag_name[1477:1489],
-	37384: _IFDtag_name[1489:1500],
-	37385: _IFDtag_name[1500:1505],
-	37386: _IFDtag_name[1505:1516],
-	37396: _IFDtag_name[1516:1527],
-	37500: _IFDtag_name[1527:1536],
-	37510: _IFDtag_name[1536:1547],
-	37520: _IFDtag_name[1547:1557],
-	37521: _IFDtag_name[1557:1575],
-	37522: _IFDtag_name[1575:1594],
-	37888: _IFDtag_name[1594:1601],
-	40960: _IFDtag_name[1601:1616],
-	40961: _IFDtag_name[1616:1626],
-	40962: _IFDtag_name[1626:1641],
-	40963: _IFDtag_name[1641:1656],
-	40964: _IFDtag_name[1656:1672],
-	40965: _IFDtag_name[1672:1691],
-	41483: _IFDtag_name[1691:1702],
-	41484: _IFDtag_name[1702:1726],
-	41486: _IFDtag_name[1726:1747],
-	41487: _IFDtag_name[1747:1768],
-	41488: _IFDtag_name[1768:1792],
-	41492: _IFDtag_name[1792:1807],
-	41493: _IFDtag_name[1807:1820],
-	41495: _IFDtag_name[1820:1833],
-	41728: _IFDtag_name[1833:1843],
-	41729: _IFDtag_name[1843:1852],
-	41730: _IFDtag_name[1852:1862],
-	41985: _IFDtag_name[1862:1876],
-	41986: _IFDtag_name[1876:1888],
-	41987: _IFDtag_name[1888:1900],
-	41988: _IFDtag_name[1900:1916],
-	41989: _IFDtag_name[1916:1937],
-	41990: _IFDtag_name[1937:1953],
-	41991: _IFDtag_name[1953:1964],
-	41992: _IFDtag_name[1964:1972],
-	41993: _IFDtag_name[1972:1982],
-	41994: _IFDtag_name[1982:1991],
-	41995: _IFDtag_name[1991:2015],
-	41996: _IFDtag_name[2015:2035],
-	42016: _IFDtag_name[2035:2048],
-	42034: _IFDtag_name[2048:2065],
-	42036: _IFDtag_name[2065:2074],
-	42240: _IFDtag_name[2074:2079],
-	45056: _IFDtag_name[2079:2089],
-	45057: _IFDtag_name[2089:2100],
-	45088: _IFDtag_name[2100:2113],
-	45098: _IFDtag_name[2113:2121],
-	45099: _IFDtag_name[2121:2134],
-	45100: _IFDtag_name[2134:2150],
-	50341: _IFDtag_name[2150:2168],
-	50719: _IFDtag_name[2168:2185],
-	50720: _IFDtag_name[2185:2200],
-	50740: _IFDtag_name[2200:2214],
+	322:   _IFDtag_name[276:285],
+	323:   _IFDtag_name[285:295],
+	324:   _IFDtag_name[295:306],
+	325:   _IFDtag_name[306:320],
+	330:   _IFDtag_name[320:327],
+	513:   _IFDtag_name[327:348],
+	514:   _IFDtag_name[348:375],
+	529:   _IFDtag_name[375:392],
+	531:   _IFDtag_name[392:408],
+	700:   _IFDtag_name[408:411],
+	12288: _IFDtag_name[411:419],
+	28672: _IFDtag_name[419:434],
+	28688: _IFDtag_name[434:443],
+	29184: _IFDtag_name[443:458],
+	29185: _IFDtag_name[458:473],
+	29217: _IFDtag_name[473:485],
+	29248: _IFDtag_name[485:492],
+	29249: _IFDtag_name[492:500],
+	29264: _IFDtag_name[500:507],
+	29440: _IFDtag_name[507:517],
+	29442: _IFDtag_name[517:534],
+	29443: _IFDtag_name[534:547],
+	29456: _IFDtag_name[547:558],
+	29459: _IFDtag_name[558:571],
+	29824: _IFDtag_name[571:591],
+	29825: _IFDtag_name[591:609],
+	29826: _IFDtag_name[609:629],
+	29827: _IFDtag_name[629:646],
+	29828: _IFDtag_name[646:663],
+	29830: _IFDtag_name[663:686],
+	29856: _IFDtag_name[686:707],
+	29857: _IFDtag_name[707:728],
+	29858: _IFDtag_name[728:742],
+	29859: _IFDtag_name[742:756],
+	29888: _IFDtag_name[756:766],
+	30720: _IFDtag_name[766:777],
+	30752: _IFDtag_name[777:798],
+	30753: _IFDtag_name[798:817],
+	30754: _IFDtag_name[817:838],
+	30755: _IFDtag_name[838:856],
+	30756: _IFDtag_name[856:874],
+	30757: _IFDtag_name[874:892],
+	30758: _IFDtag_name[892:916],
+	30759: _IFDtag_name[916:941],
+	30760: _IFDtag_name[941:966],
+	30761: _IFDtag_name[966:991],
+	30762: _IFDtag_name[991:1008],
+	30763: _IFDtag_name[1008:1025],
+	30764: _IFDtag_name[1025:1042],
+	30765: _IFDtag_name[1042:1059],
+	30847: _IFDtag_name[1059:1069],
+	31101: _IFDtag_name[1069:1089],
+	31104: _IFDtag_name[1089:1118],
+	31106: _IFDtag_name[1118:1138],
+	33421: _IFDtag_name[1138:1157],
+	33422: _IFDtag_name[1157:1168],
+	33434: _IFDtag_name[1168:1180],
+	33437: _IFDtag_name[1180:1187],
+	34665: _IFDtag_name[1187:1194],
+	34675: _IFDtag_name[1194:1211],
+	34850: _IFDtag_name[1211:1226],
+	34852: _IFDtag_name[1226:1245],
+	34853: _IFDtag_name[1245:1251],
+	34855: _IFDtag_name[1251:1266],
+	34856: _IFDtag_name[1266:1270],
+	34864: _IFDtag_name[1270:1285],
+	34866: _IFDtag_name[1285:1309],
+	36864: _IFDtag_name[1309:1320],
+	36867: _IFDtag_name[1320:1336],
+	36868: _IFDtag_name[1336:1353],
+	36880: _IFDtag_name[1353:1363],
+	36881: _IFDtag_name[1363:1381],
+	36882: _IFDtag_name[1381:1400],
+	37121: _IFDtag_name[1400:1423],
+	37122: _IFDtag_name[1423:1445],
+	37377: _IFDtag_name[1445:1462],
+	37378: _IFDtag_name[1462:1475],
+	37379: _IFDtag_name[1475:1490],
+	37380: _IFDtag_name[1490:1507],
+	37381: _IFDtag_name[1507:1523],
+	37382: _IFDtag_name[1523:1538],
+	37383: _IFDtag_name[1538:1550],
+	37384: _IFDtag_name[1550:1561],
+	37385: _IFDtag_name[1561:1566],
+	37386: _IFDtag_name[1566:1577],
+	37396: _IFDtag_name[1577:1588],
+	37500: _IFDtag_name[1588:1597],
+	37510: _IFDtag_name[1597:1608],
+	37520: _IFDtag_name[1608:1618],
+	37521: _IFDtag_name[1618:1636],
+	37522: _IFDtag_name[1636:1655],
+	37888: _IFDtag_name[1655:1662],
+	40960: _IFDtag_name[1662:1677],
+	40961: _IFDtag_name[1677:1687],
+	40962: _IFDtag_name[1687:1702],
+	40963: _IFDtag_name[1702:1717],
+	40964: _IFDtag_name[1717:1733],
+	40965: _IFDtag_name[1733:1752],
+	41483: _IFDtag_name[1752:1763],
+	41484: _IFDtag_name[1763:1787],
+	41486: _IFDtag_name[1787:1808],
+	41487: _IFDtag_name[1808:1829],
+	41488: _IFDtag_name[1829:1853],
+	41492: _IFDtag_name[1853:1868],
+	41493: _IFDtag_name[1868:1881],
+	41495: _IFDtag_name[1881:1894],
+	41728: _IFDtag_name[1894:1904],
+	41729: _IFDtag_name[1904:1913],
+	41730: _IFDtag_name[1913:1923],
+	41985: _IFDtag_name[1923:1937],
+	41986: _IFDtag_name[1937:1949],
+	41987: _IFDtag_name[1949:1961],
+	41988: _IFDtag_name[1961:1977],
+	41989: _IFDtag_name[1977:1998],
+	41990: _IFDtag_name[1998:2014],
+	41991: _IFDtag_name[2014:2025],
+	41992: _IFDtag_name[2025:2033],
+	41993: _IFDtag_name[2033:2043],
+	41994: _IFDtag_name[2043:2052],
+	41995: _IFDtag_name[2052:2076],
+	41996: _IFDtag_name[2076:2096],
+	42016: _IFDtag_name[2096:2109],
+	42033: _IFDtag_name[2109:2125],
+	42034: _IFDtag_name[2125:2142],
+	42036: _IFDtag_name[2142:2151],
+	42240: _IFDtag_name[2151:2156],
+	45056: _IFDtag_name[2156:2166],
+	45057: _IFDtag_name[2166:2177],
+	45088: _IFDtag_name[2177:2190],
+	45098: _IFDtag_name[2190:2198],
+	45099: _IFDtag_name[2198:2211],
+	45100: _IFDtag_name[2211:2227],
+	50341: _IFDtag_name[2227:2245],
+	50719: _IFDtag_name[2245:2262],
+	50720: _IFDtag_name[2262:2277],
+	50740: _IFDtag_name[2277:2291],
+	50829: _IFDtag_name[2291:2301],
 }
 
 func (i IFDtag) String() string {
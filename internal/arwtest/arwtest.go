@@ -0,0 +1,440 @@
+//Package arwtest writes small Sony ARW files with known content, so the decoder can be tested without camera files.
+package arwtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+//Format is the way the raw data is stored, the values match the SonyRawFileType tag.
+type Format uint16
+
+const (
+	Raw14 Format = iota
+	Raw12
+	CRAW
+	CRAWLossless
+)
+
+//Tag numbers written by File, taken from http://www.exiv2.org/tags.html
+const (
+	tagNewSubFileType        = 254
+	tagImageWidth            = 256
+	tagImageHeight           = 257
+	tagBitsPerSample         = 258
+	tagCompression           = 259
+	tagPhotometric           = 262
+	tagMake                  = 271
+	tagModel                 = 272
+	tagStripOffsets          = 273
+	tagSamplesPerPixel       = 277
+	tagRowsPerStrip          = 278
+	tagStripByteCounts       = 279
+	tagSubIFDs               = 330
+	tagJPEGOffset            = 513
+	tagJPEGLength            = 514
+	tagSonyRawFileType       = 0x7000
+	tagSonyCurve             = 0x7010
+	tagBlackLevel2           = 0x7310
+	tagWBRGGBLevels          = 0x7313
+	tagCFARepeatPatternDim   = 0x828d
+	tagCFAPattern2           = 0x828e
+	tagExposureTime          = 33434
+	tagFNumber               = 33437
+	tagExifIFD               = 34665
+	tagGPSIFD                = 34853
+	tagExposureProgram       = 34850
+	tagISOSpeedRatings       = 34855
+	tagDateTimeOriginal      = 36867
+	tagOffsetTimeOriginal    = 36881
+	tagMeteringMode          = 37383
+	tagFlash                 = 37385
+	tagFocalLength           = 37386
+	tagSubsecTimeOriginal    = 37521
+	tagFocalLengthIn35mmFilm = 41989
+	tagBodySerialNumber      = 42033
+	tagLensSpecification     = 42034
+	tagLensModel             = 42036
+)
+
+//TIFF field types, CIPA DC-008-2012 Chapter 4.6.2.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSShort    = 8
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+//Tag is a single IFD entry, the value is stored in the byte order of the file.
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Count uint32
+	Value []byte
+}
+
+func newTag(id, typ uint16, count int, values interface{}) Tag {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, values)
+	return Tag{ID: id, Type: typ, Count: uint32(count), Value: buf.Bytes()}
+}
+
+//Byte returns a BYTE tag.
+func Byte(id uint16, values ...uint8) Tag { return newTag(id, typeByte, len(values), values) }
+
+//Undefined returns an UNDEFINED tag.
+func Undefined(id uint16, values ...uint8) Tag { return newTag(id, typeUndefined, len(values), values) }
+
+//ASCII returns a NUL terminated ASCII tag.
+func ASCII(id uint16, value string) Tag {
+	return newTag(id, typeASCII, len(value)+1, append([]byte(value), 0))
+}
+
+//Short returns a SHORT tag.
+func Short(id uint16, values ...uint16) Tag { return newTag(id, typeShort, len(values), values) }
+
+//SShort returns an SSHORT tag.
+func SShort(id uint16, values ...int16) Tag { return newTag(id, typeSShort, len(values), values) }
+
+//Long returns a LONG tag.
+func Long(id uint16, values ...uint32) Tag { return newTag(id, typeLong, len(values), values) }
+
+//SLong returns an SLONG tag.
+func SLong(id uint16, values ...int32) Tag { return newTag(id, typeSLong, len(values), values) }
+
+//Rational returns a RATIONAL tag, values are numerator and denominator pairs.
+func Rational(id uint16, values ...uint32) Tag {
+	return newTag(id, typeRational, len(values)/2, values)
+}
+
+//SRational returns an SRATIONAL tag, values are numerator and denominator pairs.
+func SRational(id uint16, values ...int32) Tag {
+	return newTag(id, typeSRational, len(values)/2, values)
+}
+
+//Exif holds the shooting information written to the Exif IFD, zero values are left out.
+type Exif struct {
+	//ExposureTime, FNumber and FocalLength are numerator and denominator pairs.
+	ExposureTime          [2]uint32
+	FNumber               [2]uint32
+	FocalLength           [2]uint32
+	ISO                   uint16
+	FocalLengthIn35mmFilm uint16
+	ExposureProgram       uint16
+	MeteringMode          uint16
+	Flash                 uint16
+	DateTimeOriginal      string
+	OffsetTimeOriginal    string
+	SubsecTimeOriginal    string
+	LensModel             string
+	BodySerialNumber      string
+	//LensSpecification holds the minimum and maximum focal length followed by the minimum f-number at both.
+	LensSpecification [4][2]uint32
+}
+
+//File describes an ARW file. The zero value of every field other than the dimensions and pixels gets a sensible default.
+type File struct {
+	Width  int
+	Height int
+	Format Format
+	//Pixels holds the mosaic in row-major order. For Raw14, Raw12 and CRAWLossless these are the stored sensor values,
+	//for CRAW they are the 11 bit values before the Sony curve is applied. CRAW is lossy, see QuantizeCRAW.
+	Pixels []uint16
+
+	//Curve holds the four SonyCurve knots, a zero curve means a linear one.
+	Curve        [4]uint16
+	BlackLevel   [4]uint16
+	WhiteBalance [4]int16
+	//CFAPattern defaults to RGGB.
+	CFAPattern [4]uint8
+
+	Make  string
+	Model string
+	Exif  Exif
+	//GPS is written as the GPS IFD when it is set.
+	GPS []Tag
+	//JPEG is stored as the preview image in IFD0.
+	JPEG []byte
+	//Thumbnail is stored in IFD1, which is only written when it is set.
+	Thumbnail []byte
+	//FullSizeJPEG is stored as the strip of a second SubIFD, which is only written when it is set.
+	FullSizeJPEG []byte
+
+	//IFD0Tags and RawTags are added to IFD0 and the raw SubIFD, replacing tags with the same ID.
+	IFD0Tags []Tag
+	RawTags  []Tag
+}
+
+//Bytes returns the ARW file. IFD0 comes first and starts with NewSubFileType and Compression so the file matches
+//the ARW magic.
+func (f File) Bytes() []byte {
+	strip, bitDepth, compression := f.strip()
+
+	subIFDs := Long(tagSubIFDs, 0)
+	if f.FullSizeJPEG != nil {
+		subIFDs = Long(tagSubIFDs, 0, 0)
+	}
+	//Like Sony IFD0 has no strips, the preview is stored as an old style JPEG.
+	ifd0 := []Tag{Long(tagNewSubFileType, 1), Short(tagCompression, 6), subIFDs, Long(tagExifIFD, 0)}
+	if f.Make != "" {
+		ifd0 = append(ifd0, ASCII(tagMake, f.Make))
+	}
+	if f.Model != "" {
+		ifd0 = append(ifd0, ASCII(tagModel, f.Model))
+	}
+	if f.GPS != nil {
+		ifd0 = append(ifd0, Long(tagGPSIFD, 0))
+	}
+	if f.JPEG != nil {
+		ifd0 = append(ifd0, Long(tagJPEGOffset, 0), Long(tagJPEGLength, uint32(len(f.JPEG))))
+	}
+	ifd0 = merge(ifd0, f.IFD0Tags)
+
+	cfa := f.CFAPattern
+	if cfa == [4]uint8{} {
+		cfa = [4]uint8{0, 1, 1, 2}
+	}
+	raw := []Tag{
+		Long(tagNewSubFileType, 0),
+		Long(tagImageWidth, uint32(f.Width)),
+		Long(tagImageHeight, uint32(f.Height)),
+		Short(tagBitsPerSample, bitDepth),
+		Short(tagCompression, compression),
+		Short(tagPhotometric, 32803),
+		Long(tagStripOffsets, 0),
+		Short(tagSamplesPerPixel, 1),
+		Long(tagRowsPerStrip, uint32(f.Height)),
+		Long(tagStripByteCounts, uint32(len(strip))),
+		Short(tagSonyRawFileType, uint16(f.Format)),
+		Short(tagSonyCurve, f.Curve[:]...),
+		Short(tagBlackLevel2, f.BlackLevel[:]...),
+		SShort(tagWBRGGBLevels, f.WhiteBalance[:]...),
+		Short(tagCFARepeatPatternDim, 2, 2),
+		Byte(tagCFAPattern2, cfa[:]...),
+	}
+	raw = merge(raw, f.RawTags)
+
+	//The full size JPEG and the thumbnail are stored the way Sony does, with JPEG compression.
+	var full, ifd1 []Tag
+	if f.FullSizeJPEG != nil {
+		full = []Tag{
+			Long(tagNewSubFileType, 0),
+			Short(tagCompression, 6),
+			Long(tagStripOffsets, 0),
+			Long(tagStripByteCounts, uint32(len(f.FullSizeJPEG))),
+		}
+	}
+	if f.Thumbnail != nil {
+		ifd1 = []Tag{
+			Long(tagNewSubFileType, 1),
+			Short(tagCompression, 6),
+			Long(tagJPEGOffset, 0),
+			Long(tagJPEGLength, uint32(len(f.Thumbnail))),
+		}
+	}
+
+	exif := f.Exif.tags()
+	gps := merge(nil, f.GPS)
+
+	//Every IFD is followed by the values that don't fit in its entries, then the JPEGs and raw data follow.
+	const headerSize = 8
+	ifd0Offset := headerSize
+	rawOffset := ifd0Offset + ifdSize(ifd0)
+	fullOffset := rawOffset + ifdSize(raw)
+	exifOffset := fullOffset + optionalIFDSize(full)
+	gpsOffset := exifOffset + ifdSize(exif)
+	ifd1Offset := gpsOffset + optionalIFDSize(gps)
+	jpegOffset := ifd1Offset + optionalIFDSize(ifd1)
+	fullJPEGOffset := jpegOffset + len(f.JPEG)
+	thumbnailOffset := fullJPEGOffset + len(f.FullSizeJPEG)
+	stripOffset := thumbnailOffset + len(f.Thumbnail)
+	stripOffset += stripOffset & 1
+
+	if f.FullSizeJPEG != nil {
+		set(ifd0, tagSubIFDs, uint32(rawOffset), uint32(fullOffset))
+	} else {
+		set(ifd0, tagSubIFDs, uint32(rawOffset))
+	}
+	set(ifd0, tagExifIFD, uint32(exifOffset))
+	set(ifd0, tagGPSIFD, uint32(gpsOffset))
+	set(ifd0, tagJPEGOffset, uint32(jpegOffset))
+	set(raw, tagStripOffsets, uint32(stripOffset))
+	set(full, tagStripOffsets, uint32(fullJPEGOffset))
+	set(ifd1, tagJPEGOffset, uint32(thumbnailOffset))
+
+	var next uint32
+	if ifd1 != nil {
+		next = uint32(ifd1Offset)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(ifd0Offset))
+	writeIFD(&buf, ifd0, next)
+	writeIFD(&buf, raw, 0)
+	if full != nil {
+		writeIFD(&buf, full, 0)
+	}
+	writeIFD(&buf, exif, 0)
+	if gps != nil {
+		writeIFD(&buf, gps, 0)
+	}
+	if ifd1 != nil {
+		writeIFD(&buf, ifd1, 0)
+	}
+	buf.Write(f.JPEG)
+	buf.Write(f.FullSizeJPEG)
+	buf.Write(f.Thumbnail)
+	if buf.Len() < stripOffset {
+		buf.WriteByte(0)
+	}
+	buf.Write(strip)
+	return buf.Bytes()
+}
+
+func (e Exif) tags() []Tag {
+	var tags []Tag
+	rational := func(id uint16, v [2]uint32) {
+		if v[1] != 0 {
+			tags = append(tags, Rational(id, v[0], v[1]))
+		}
+	}
+	short := func(id uint16, v uint16) {
+		if v != 0 {
+			tags = append(tags, Short(id, v))
+		}
+	}
+	ascii := func(id uint16, v string) {
+		if v != "" {
+			tags = append(tags, ASCII(id, v))
+		}
+	}
+
+	rational(tagExposureTime, e.ExposureTime)
+	rational(tagFNumber, e.FNumber)
+	rational(tagFocalLength, e.FocalLength)
+	short(tagISOSpeedRatings, e.ISO)
+	short(tagFocalLengthIn35mmFilm, e.FocalLengthIn35mmFilm)
+	short(tagExposureProgram, e.ExposureProgram)
+	short(tagMeteringMode, e.MeteringMode)
+	short(tagFlash, e.Flash)
+	ascii(tagDateTimeOriginal, e.DateTimeOriginal)
+	ascii(tagOffsetTimeOriginal, e.OffsetTimeOriginal)
+	ascii(tagSubsecTimeOriginal, e.SubsecTimeOriginal)
+	ascii(tagLensModel, e.LensModel)
+	ascii(tagBodySerialNumber, e.BodySerialNumber)
+	if e.LensSpecification != [4][2]uint32{} {
+		var values []uint32
+		for _, v := range e.LensSpecification {
+			values = append(values, v[0], v[1])
+		}
+		tags = append(tags, Rational(tagLensSpecification, values...))
+	}
+
+	sort.Slice(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+	return tags
+}
+
+//strip returns the raw data in the requested format along with the BitsPerSample and Compression values.
+func (f File) strip() ([]byte, uint16, uint16) {
+	switch f.Format {
+	case Raw12:
+		return PackRaw12(f.Pixels, f.Width, f.Height), 12, 1
+	case CRAW:
+		return PackCRAW(f.Pixels, f.Width, f.Height), 8, 32767
+	case CRAWLossless:
+		return EncodeLossless(f.Pixels, f.Width, f.Height, 14), 14, 7
+	default:
+		buf := make([]byte, 2*len(f.Pixels))
+		for i, v := range f.Pixels {
+			binary.LittleEndian.PutUint16(buf[2*i:], v)
+		}
+		return buf, 14, 1
+	}
+}
+
+//merge adds extra to tags, replacing tags with the same ID, and sorts the result by ID.
+func merge(tags, extra []Tag) []Tag {
+	for _, e := range extra {
+		replaced := false
+		for i := range tags {
+			if tags[i].ID == e.ID {
+				tags[i] = e
+				replaced = true
+			}
+		}
+		if !replaced {
+			tags = append(tags, e)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+	return tags
+}
+
+//set changes the values of a LONG tag.
+func set(tags []Tag, id uint16, values ...uint32) {
+	for i := range tags {
+		if tags[i].ID == id {
+			for n, v := range values {
+				binary.LittleEndian.PutUint32(tags[i].Value[4*n:], v)
+			}
+		}
+	}
+}
+
+//ifdSize is the size of an IFD including the values stored after it, every value is word aligned.
+func ifdSize(tags []Tag) int {
+	size := 2 + 12*len(tags) + 4
+	for _, t := range tags {
+		if len(t.Value) > 4 {
+			size += len(t.Value) + len(t.Value)&1
+		}
+	}
+	return size
+}
+
+//optionalIFDSize is the size of an IFD which is left out when it has no tags.
+func optionalIFDSize(tags []Tag) int {
+	if tags == nil {
+		return 0
+	}
+	return ifdSize(tags)
+}
+
+//writeIFD writes the IFD at the current position of buf followed by its values, next is the offset of the next IFD.
+func writeIFD(buf *bytes.Buffer, tags []Tag, next uint32) {
+	start := buf.Len()
+	le := binary.LittleEndian
+	values := start + 2 + 12*len(tags) + 4
+
+	binary.Write(buf, le, uint16(len(tags)))
+	for _, t := range tags {
+		binary.Write(buf, le, t.ID)
+		binary.Write(buf, le, t.Type)
+		binary.Write(buf, le, t.Count)
+		if len(t.Value) <= 4 {
+			var inline [4]byte
+			copy(inline[:], t.Value)
+			buf.Write(inline[:])
+		} else {
+			binary.Write(buf, le, uint32(values))
+			values += len(t.Value) + len(t.Value)&1
+		}
+	}
+	binary.Write(buf, le, next)
+
+	for _, t := range tags {
+		if len(t.Value) > 4 {
+			buf.Write(t.Value)
+			if len(t.Value)&1 != 0 {
+				buf.WriteByte(0)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package arwtest
+
+import "bytes"
+
+//PackRaw12 packs 12 bit values two to every three bytes, the low nibble of the second byte belongs to the first value.
+//With an odd width the last value of a row takes a byte and a half, the row is padded to a whole byte.
+func PackRaw12(pix []uint16, width, height int) []byte {
+	rowLength := (width*3 + 1) / 2
+	buf := make([]byte, rowLength*height)
+	for y := 0; y < height; y++ {
+		row := pix[y*width : (y+1)*width]
+		for x, j := 0, y*rowLength; x+1 < width; x, j = x+2, j+3 {
+			buf[j] = byte(row[x])
+			buf[j+1] = byte(row[x]>>8&0x0f) | byte(row[x+1]<<4)
+			buf[j+2] = byte(row[x+1] >> 4)
+		}
+		if width%2 == 1 {
+			j := y*rowLength + rowLength - 2
+			buf[j] = byte(row[width-1])
+			buf[j+1] = byte(row[width-1] >> 8 & 0x0f)
+		}
+	}
+	return buf
+}
+
+//crawBlock returns the indices into a row of the 16 pixels stored in the block at column x of a 32 pixel chunk.
+//The first block of every chunk holds the even columns, the second block the odd ones.
+func crawBlock(x, block int) [16]int {
+	var cols [16]int
+	for i := range cols {
+		cols[i] = x + block + 2*i
+	}
+	return cols
+}
+
+//encodeCRAWBlock stores 16 11 bit values as the maximum, minimum, their positions and 7 bit deltas from the minimum.
+//The deltas lose precision once the range of the block exceeds 7 bits, bits above the lowest 11 are dropped.
+func encodeCRAWBlock(values [16]uint16) ([16]byte, [16]uint16) {
+	for i := range values {
+		values[i] &= 0x7ff
+	}
+	maxIdx, minIdx := 0, 0
+	for i, v := range values {
+		if v > values[maxIdx] {
+			maxIdx = i
+		}
+		if v < values[minIdx] {
+			minIdx = i
+		}
+	}
+	if maxIdx == minIdx {
+		minIdx = (maxIdx + 1) % len(values)
+	}
+	max, min := values[maxIdx], values[minIdx]
+
+	var shift uint
+	for shift < 4 && 0x80<<shift <= max-min {
+		shift++
+	}
+
+	var block [16]byte
+	var decoded [16]uint16
+	word := uint64(max) | uint64(min)<<11 | uint64(maxIdx)<<22 | uint64(minIdx)<<26
+	var hi uint64
+	bit := uint(30)
+	for i, v := range values {
+		switch i {
+		case maxIdx:
+			decoded[i] = max
+			continue
+		case minIdx:
+			decoded[i] = min
+			continue
+		}
+		delta := uint64((v - min) >> shift)
+		if delta > 0x7f {
+			delta = 0x7f
+		}
+		decoded[i] = min + uint16(delta)<<shift
+		if decoded[i] > 0x7ff {
+			decoded[i] = 0x7ff
+		}
+
+		//The 128 bit block is split over two words, a delta can straddle them.
+		if bit < 64 {
+			word |= delta << bit
+			if bit > 64-7 {
+				hi |= delta >> (64 - bit)
+			}
+		} else {
+			hi |= delta << (bit - 64)
+		}
+		bit += 7
+	}
+
+	for i := 0; i < 8; i++ {
+		block[i] = byte(word >> (8 * uint(i)))
+		block[8+i] = byte(hi >> (8 * uint(i)))
+	}
+	return block, decoded
+}
+
+//crawBlocks runs encodeCRAWBlock over every block of the image.
+func crawBlocks(pix []uint16, width, height int, block func(y int, cols [16]int, values [16]uint16)) {
+	for y := 0; y < height; y++ {
+		for x := 0; x+32 <= width; x += 32 {
+			for b := 0; b < 2; b++ {
+				cols := crawBlock(x, b)
+				var values [16]uint16
+				for i, c := range cols {
+					values[i] = pix[y*width+c]
+				}
+				block(y, cols, values)
+			}
+		}
+	}
+}
+
+//PackCRAW compresses 11 bit values in to CRAW blocks, the width has to be a multiple of 32.
+func PackCRAW(pix []uint16, width, height int) []byte {
+	buf := make([]byte, 0, width*height)
+	crawBlocks(pix, width, height, func(y int, cols [16]int, values [16]uint16) {
+		block, _ := encodeCRAWBlock(values)
+		buf = append(buf, block[:]...)
+	})
+	return buf
+}
+
+//QuantizeCRAW returns the 11 bit values a decoder reads back from PackCRAW.
+func QuantizeCRAW(pix []uint16, width, height int) []uint16 {
+	out := make([]uint16, len(pix))
+	crawBlocks(pix, width, height, func(y int, cols [16]int, values [16]uint16) {
+		_, decoded := encodeCRAWBlock(values)
+		for i, c := range cols {
+			out[y*width+c] = decoded[i]
+		}
+	})
+	return out
+}
+
+//EncodeLossless stores a mosaic the way Sony does in lossless compressed files,
+//as a lossless JPEG in which every 2x2 CFA block is a single sample with four components.
+func EncodeLossless(pix []uint16, width, height, precision int) []byte {
+	samples := make([]uint16, 0, len(pix))
+	for y := 0; y+1 < height; y += 2 {
+		for x := 0; x+1 < width; x += 2 {
+			samples = append(samples, pix[y*width+x], pix[y*width+x+1], pix[(y+1)*width+x], pix[(y+1)*width+x+1])
+		}
+	}
+	return EncodeLJPEG(samples, width/2, height/2, 4, precision)
+}
+
+//JPEG markers, ITU T.81 Table B.1.
+const (
+	markerSOI = 0xd8
+	markerEOI = 0xd9
+	markerSOF = 0xc3
+	markerDHT = 0xc4
+	markerSOS = 0xda
+)
+
+//EncodeLJPEG writes a minimal lossless JPEG with predictor 1 and a Huffman table giving every category a 5 bit code.
+func EncodeLJPEG(pix []uint16, width, height, components, precision int) []byte {
+	var out bytes.Buffer
+	segment := func(marker byte, data ...byte) {
+		out.Write([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+		out.Write(data)
+	}
+
+	out.Write([]byte{0xff, markerSOI})
+
+	dht := []byte{0x00, 0, 0, 0, 0, 17, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for i := 0; i <= 16; i++ {
+		dht = append(dht, byte(i))
+	}
+	segment(markerDHT, dht...)
+
+	sof := []byte{byte(precision), byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(components)}
+	for c := 0; c < components; c++ {
+		sof = append(sof, byte(c+1), 0x11, 0)
+	}
+	segment(markerSOF, sof...)
+
+	sos := []byte{byte(components)}
+	for c := 0; c < components; c++ {
+		sos = append(sos, byte(c+1), 0x00)
+	}
+	sos = append(sos, 1, 0, 0)
+	segment(markerSOS, sos...)
+
+	var acc uint64
+	var n uint
+	put := func(v uint64, bits uint) {
+		acc = acc<<bits | v&(1<<bits-1)
+		n += bits
+		for n >= 8 {
+			c := byte(acc >> (n - 8))
+			out.WriteByte(c)
+			if c == 0xff {
+				out.WriteByte(0)
+			}
+			n -= 8
+		}
+	}
+
+	rowLength := width * components
+	for y := 0; y < height; y++ {
+		for i := 0; i < rowLength; i++ {
+			var pred int
+			switch {
+			case y == 0 && i < components:
+				pred = 1 << uint(precision-1)
+			case i < components:
+				pred = int(pix[(y-1)*rowLength+i])
+			default:
+				pred = int(pix[y*rowLength+i-components])
+			}
+			diff := int(int16(int(pix[y*rowLength+i]) - pred))
+
+			s, mag := uint(0), diff
+			if mag < 0 {
+				mag = -mag
+			}
+			for mag > 0 {
+				s++
+				mag >>= 1
+			}
+			put(uint64(s), 5)
+			if s > 0 && s < 16 {
+				if diff < 0 {
+					diff--
+				}
+				put(uint64(diff), s)
+			}
+		}
+	}
+	if n > 0 {
+		put(0xff, 8-n)
+	}
+
+	out.Write([]byte{0xff, markerEOI})
+	return out.Bytes()
+}
@@ -0,0 +1,193 @@
+package arw
+
+import (
+	"image"
+	"math"
+)
+
+//LensCorrection selects which of the lens corrections stored by the camera are applied, the flags can be combined.
+type LensCorrection uint8
+
+const (
+	//CorrectVignetting brightens the corners by the falloff the camera recorded for the lens.
+	CorrectVignetting LensCorrection = 1 << iota
+	//CorrectChromaticAberration scales the red and blue channels radially so they line up with green.
+	CorrectChromaticAberration
+	//CorrectDistortion remaps the image radially to undo barrel and pincushion distortion.
+	CorrectDistortion
+
+	CorrectAll = CorrectVignetting | CorrectChromaticAberration | CorrectDistortion
+)
+
+//lensProfile holds the corrections from the VignettingCorrParams, ChromaticAberrationCorrParams and DistortionCorrParams tags.
+//Each is sampled at knots spread evenly from the centre of the image to its corners.
+type lensProfile struct {
+	//vignetting holds the gain bringing the corners up to the brightness of the centre.
+	vignetting []float64
+	//red, blue and distortion hold the factor by which the radius is scaled to find where a pixel came from.
+	red, blue  []float64
+	distortion []float64
+}
+
+//knots returns the values following the count in the first element of a correction tag, or nil when it doesn't fit.
+func knots(params []int16, count int) []int16 {
+	if count < 2 || 1+count > len(params) {
+		return nil
+	}
+	return params[1 : 1+count]
+}
+
+//newLensProfile decodes the correction tags, the scaling of the values follows what has been worked out from comparing
+//with the camera's own JPEGs.
+func newLensProfile(rw rawDetails) lensProfile {
+	var p lensProfile
+	if len(rw.vignettingParams) > 0 {
+		for _, v := range knots(rw.vignettingParams, int(rw.vignettingParams[0])) {
+			falloff := math.Pow(2, 0.5-math.Pow(2, float64(v)/(1<<13)-1))
+			p.vignetting = append(p.vignetting, 1/falloff)
+		}
+	}
+	if len(rw.caParams) > 0 {
+		n := int(rw.caParams[0]) / 2
+		if params := knots(rw.caParams, 2*n); params != nil {
+			for i := 0; i < n; i++ {
+				p.red = append(p.red, 1+float64(params[i])/(1<<21))
+				p.blue = append(p.blue, 1+float64(params[n+i])/(1<<21))
+			}
+		}
+	}
+	if len(rw.distortionParams) > 0 {
+		for _, v := range knots(rw.distortionParams, int(rw.distortionParams[0])) {
+			p.distortion = append(p.distortion, 1+float64(v)/(1<<14))
+		}
+	}
+	return p
+}
+
+//interpolate returns the value of a correction at radius r, where 1 is the corner of the image.
+//Between knots the values are interpolated linearly, past the last knot its value is kept.
+func interpolate(values []float64, r float64) float64 {
+	if len(values) == 0 {
+		return 1
+	}
+	p := r * float64(len(values)-1)
+	i := int(p)
+	if i >= len(values)-1 {
+		return values[len(values)-1]
+	}
+	t := p - float64(i)
+	return values[i]*(1-t) + values[i+1]*t
+}
+
+//radius measures the distance from the centre of rect, normalised so the corners are at 1.
+type radius struct {
+	cx, cy, scale float64
+}
+
+func newRadius(rect image.Rectangle) radius {
+	cx, cy := float64(rect.Min.X+rect.Max.X-1)/2, float64(rect.Min.Y+rect.Max.Y-1)/2
+	corner := math.Hypot(cx-float64(rect.Min.X), cy-float64(rect.Min.Y))
+	if corner == 0 {
+		corner = 1
+	}
+	return radius{cx, cy, 1 / corner}
+}
+
+func (r radius) at(x, y float64) float64 {
+	return math.Hypot(x-r.cx, y-r.cy) * r.scale
+}
+
+//vignettingGain returns the gain for every photosite of b, nil when vignetting isn't corrected.
+//The radius is measured from the crop, whose centre is taken to be the optical axis.
+func (p lensProfile) vignettingGain(b *Bayer) func(x, y int) float64 {
+	if p.vignetting == nil {
+		return nil
+	}
+	//The gain only depends on the radius, sampling it finely saves evaluating the profile for every photosite.
+	const steps = 1024
+	var lut [steps + 2]float64
+	for i := range lut {
+		lut[i] = interpolate(p.vignetting, float64(i)/steps)
+	}
+	rad := newRadius(b.Crop)
+	return func(x, y int) float64 {
+		r := rad.at(float64(x), float64(y)) * steps
+		if r >= steps {
+			return lut[steps]
+		}
+		return lut[int(r+0.5)]
+	}
+}
+
+//correctVignetting brightens every pixel of img, demosaiced from b, by the gain for its position.
+//Values past white are kept up to the 16 bit limit, the colour conversion clips them.
+func (p lensProfile) correctVignetting(img *RGB14, b *Bayer) {
+	gain := p.vignettingGain(b)
+	if gain == nil {
+		return
+	}
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			px := &img.Pix[img.offset(x, y)]
+			g := gain(x, y)
+			px.R, px.G, px.B = balance(uint32(px.R), 0, g), balance(uint32(px.G), 0, g), balance(uint32(px.B), 0, g)
+		}
+	}
+}
+
+//remap corrects chromatic aberration and distortion by resampling every channel of img from where the lens put it.
+//The radius is measured from frame, the crop of the image.
+func (p lensProfile) remap(img *RGB14, frame image.Rectangle, corrections LensCorrection) *RGB14 {
+	var scale [3][]float64
+	if corrections&CorrectDistortion != 0 {
+		scale = [3][]float64{p.distortion, p.distortion, p.distortion}
+	}
+	ca := corrections&CorrectChromaticAberration != 0 && p.red != nil
+	if scale[0] == nil && !ca {
+		return img
+	}
+
+	rad := newRadius(frame)
+	out := NewRGB14(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			r := rad.at(float64(x), float64(y))
+			px := &out.Pix[out.offset(x, y)]
+			for c := CFARed; c <= CFABlue; c++ {
+				f := interpolate(scale[c], r)
+				if ca && c == CFARed {
+					f *= interpolate(p.red, r)
+				} else if ca && c == CFABlue {
+					f *= interpolate(p.blue, r)
+				}
+				*px.channel(c) = sample(img, rad.cx+(float64(x)-rad.cx)*f, rad.cy+(float64(y)-rad.cy)*f, c)
+			}
+		}
+	}
+	return out
+}
+
+//sample interpolates channel c of img bilinearly at x, y, positions outside of the image take the nearest edge.
+func sample(img *RGB14, x, y float64, c CFAColor) uint16 {
+	r := img.Rect
+	clamp := func(v float64, min, max int) float64 {
+		return math.Max(float64(min), math.Min(float64(max-1), v))
+	}
+	x, y = clamp(x, r.Min.X, r.Max.X), clamp(y, r.Min.Y, r.Max.Y)
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	x1, y1 := x0+1, y0+1
+	if x1 >= r.Max.X {
+		x1 = x0
+	}
+	if y1 >= r.Max.Y {
+		y1 = y0
+	}
+	tx, ty := x-float64(x0), y-float64(y0)
+	at := func(x, y int) float64 {
+		px := img.Pix[img.offset(x, y)]
+		return float64(*px.channel(c))
+	}
+	top := at(x0, y0)*(1-tx) + at(x1, y0)*tx
+	bottom := at(x0, y1)*(1-tx) + at(x1, y1)*tx
+	return uint16(top*(1-ty) + bottom*ty + 0.5)
+}
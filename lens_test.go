@@ -0,0 +1,166 @@
+package arw
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+//lensTags holds corrections for a lens which loses a stop in the corners, has its red channel 0.5% too large at the corners
+//and pincushion distortion of 2%.
+func lensTags() []arwtest.Tag {
+	vignetting := []int16{4}
+	ca := []int16{8}
+	distortion := []int16{4}
+	for i := 0; i < 4; i++ {
+		//2^(0.5 - 2^(v/8192 - 1)) is a gain of 2 at v = 8192*log2(3) = 12984.
+		vignetting = append(vignetting, int16(12984*i/3))
+		distortion = append(distortion, int16(-328*i/3))
+	}
+	for i := 0; i < 4; i++ {
+		ca = append(ca, int16(10486*i/3))
+	}
+	ca = append(ca, 0, 0, 0, 0)
+	return []arwtest.Tag{
+		arwtest.SShort(uint16(VignettingCorrParams), vignetting...),
+		arwtest.SShort(uint16(ChromaticAberrationCorrParams), ca...),
+		arwtest.SShort(uint16(DistortionCorrParams), distortion...),
+	}
+}
+
+func TestLensProfile(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	file.RawTags = lensTags()
+	rw, err := extractDetails(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newLensProfile(rw)
+	if len(p.vignetting) != 4 || len(p.red) != 4 || len(p.blue) != 4 || len(p.distortion) != 4 {
+		t.Fatalf("Unexpected knots %+v", p)
+	}
+	expected := []struct {
+		name      string
+		values    []float64
+		centre    float64
+		corner    float64
+		tolerance float64
+	}{
+		{"vignetting", p.vignetting, 1, 2, 1e-3},
+		{"red", p.red, 1, 1.005, 1e-5},
+		{"blue", p.blue, 1, 1, 0},
+		{"distortion", p.distortion, 1, 0.98, 1e-4},
+	}
+	for _, e := range expected {
+		if c := interpolate(e.values, 0); math.Abs(c-e.centre) > e.tolerance {
+			t.Errorf("%v: expected %v at the centre, got %v", e.name, e.centre, c)
+		}
+		if c := interpolate(e.values, 1); math.Abs(c-e.corner) > e.tolerance {
+			t.Errorf("%v: expected %v in the corners, got %v", e.name, e.corner, c)
+		}
+	}
+
+	//A count which doesn't fit the tag leaves the correction out.
+	rw.distortionParams = []int16{16, 1, 2}
+	if p := newLensProfile(rw); p.distortion != nil {
+		t.Error("Expected no distortion correction for a short tag, got:", p.distortion)
+	}
+}
+
+func TestLensRemap(t *testing.T) {
+	//A linear gradient is reproduced exactly by bilinear sampling, so the remapped value shows where it was sampled.
+	img := NewRGB14(image.Rect(0, 0, 101, 101))
+	for y := 0; y < 101; y++ {
+		for x := 0; x < 101; x++ {
+			v := uint16(1000 + 20*x)
+			img.Pix[img.offset(x, y)] = pixel16{R: v, G: v, B: v}
+		}
+	}
+	p := lensProfile{
+		red:        []float64{1.1, 1.1},
+		blue:       []float64{1, 1},
+		distortion: []float64{0.9, 0.9},
+	}
+
+	expected := map[LensCorrection]pixel16{
+		0:                          {R: 1000 + 20*90, G: 1000 + 20*90, B: 1000 + 20*90},
+		CorrectChromaticAberration: {R: 1000 + 20*94, G: 1000 + 20*90, B: 1000 + 20*90},
+		CorrectDistortion:          {R: 1000 + 20*86, G: 1000 + 20*86, B: 1000 + 20*86},
+		CorrectAll:                 {R: 1000 + 20*89.6, G: 1000 + 20*86, B: 1000 + 20*86},
+	}
+	for corrections, px := range expected {
+		out := p.remap(img, img.Rect, corrections)
+		if got := out.Pix[out.offset(90, 50)]; got != px {
+			t.Errorf("Corrections %b: expected %v, got %v", corrections, px, got)
+		}
+	}
+}
+
+func TestDecodeLensCorrections(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	file.RawTags = lensTags()
+	decode := func(corrections LensCorrection) *RGB14 {
+		img, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{LensCorrections: corrections})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img.(*RGB14)
+	}
+
+	plain := decode(0)
+	for _, c := range []LensCorrection{CorrectVignetting, CorrectChromaticAberration, CorrectDistortion} {
+		if reflect.DeepEqual(decode(c).Pix, plain.Pix) {
+			t.Errorf("Expected correction %b to change the image", c)
+		}
+	}
+
+	//Vignetting correction only brightens, most of all in the corners.
+	vignetting := decode(CorrectVignetting)
+	for i, px := range vignetting.Pix {
+		if px.G < plain.Pix[i].G {
+			t.Fatalf("Pixel %v got darker: %v to %v", i, plain.Pix[i], px)
+		}
+	}
+	corner, centre := vignetting.offset(0, 0), vignetting.offset(32, 3)
+	if vignetting.Pix[corner].G-plain.Pix[corner].G <= vignetting.Pix[centre].G-plain.Pix[centre].G {
+		t.Error("Expected the corners to be brightened more than the centre")
+	}
+}
+
+func TestVignettingHighlights(t *testing.T) {
+	//Green sits at 60% of the sensor white level, lifting the corners by a stop takes it past white without
+	//a single photosite having saturated. No highlight mode may treat those corners as clipped.
+	file := syntheticFile(arwtest.Raw14)
+	file.RawTags = lensTags()
+	file.CFAPattern = [4]uint8{0, 1, 1, 2}
+	response := [3]uint16{3000, 10000, 3000}
+	for y := 0; y < file.Height; y++ {
+		for x := 0; x < file.Width; x++ {
+			file.Pixels[y*file.Width+x] = 128 + response[file.CFAPattern[y%2*2+x%2]]
+		}
+	}
+
+	decode := func(mode HighlightMode) *RGB14 {
+		img, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{
+			LensCorrections: CorrectVignetting,
+			Highlights:      mode,
+			ColorSpace:      CameraRGB,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img.(*RGB14)
+	}
+	clipped := decode(HighlightClip)
+	for _, mode := range []HighlightMode{HighlightBlend, HighlightReconstruct} {
+		if img := decode(mode); !reflect.DeepEqual(img.Pix, clipped.Pix) {
+			corner := img.offset(0, 0)
+			t.Errorf("%v: expected the corners to be left alone, got %v instead of %v", mode, img.Pix[corner], clipped.Pix[corner])
+		}
+	}
+}
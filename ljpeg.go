@@ -0,0 +1,368 @@
+package arw
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//Lossless JPEG (ITU T.81 process 14, SOF3) as used by Sony for lossless compressed ARW tiles.
+
+const (
+	markerSOI = 0xd8
+	markerEOI = 0xd9
+	markerSOF = 0xc3
+	markerDHT = 0xc4
+	markerSOS = 0xda
+	markerDRI = 0xdd
+	markerRST = 0xd0
+)
+
+var (
+	errLJPEGMarker    = errors.New("lossless JPEG: missing or unexpected marker")
+	errLJPEGFrame     = errors.New("lossless JPEG: unsupported frame")
+	errLJPEGHuffman   = errors.New("lossless JPEG: invalid Huffman table")
+	errLJPEGCode      = errors.New("lossless JPEG: invalid Huffman code")
+	errLJPEGTruncated = errors.New("lossless JPEG: truncated stream")
+)
+
+//ljpegImage holds the samples of a decoded lossless JPEG frame, components are interleaved per sample.
+type ljpegImage struct {
+	width      int
+	height     int
+	components int
+	precision  int
+	pix        []uint16
+}
+
+//huffmanTable decodes a DC Huffman table through a lookup indexed by the next 16 bits of the stream.
+//Every entry holds the code length in the upper byte and the value in the lower byte, a zero length marks an invalid code.
+type huffmanTable struct {
+	lookup []uint16
+}
+
+func newHuffmanTable(counts [16]uint8, values []uint8) (*huffmanTable, error) {
+	h := &huffmanTable{lookup: make([]uint16, 1<<16)}
+
+	code, k := 0, 0
+	for l := 1; l <= 16; l++ {
+		for i := 0; i < int(counts[l-1]); i++ {
+			if k >= len(values) {
+				return nil, errLJPEGHuffman
+			}
+			start, end := code<<uint(16-l), (code+1)<<uint(16-l)
+			if end > len(h.lookup) {
+				return nil, errLJPEGHuffman
+			}
+			for j := start; j < end; j++ {
+				h.lookup[j] = uint16(l)<<8 | uint16(values[k])
+			}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return h, nil
+}
+
+//bitReader reads the entropy coded segment MSB first, removing stuffed zero bytes.
+//Once a marker or the end of the buffer is reached the stream is padded with zero bits.
+type bitReader struct {
+	buf    []byte
+	pos    int
+	acc    uint64
+	n      uint
+	marker bool
+	pad    int
+}
+
+func (b *bitReader) fill() {
+	for b.n <= 56 {
+		var c byte
+		if !b.marker && b.pos < len(b.buf) {
+			c = b.buf[b.pos]
+			if c == 0xff {
+				if b.pos+1 < len(b.buf) && b.buf[b.pos+1] == 0 {
+					b.pos += 2
+				} else {
+					b.marker = true
+					c = 0
+				}
+			} else {
+				b.pos++
+			}
+		} else {
+			b.pad++
+		}
+		b.acc |= uint64(c) << (56 - b.n)
+		b.n += 8
+	}
+}
+
+//overrun reports whether more than a few bytes of padding have been decoded, which only happens on truncated data.
+func (b *bitReader) overrun() bool {
+	return b.pad*8-int(b.n) > 64
+}
+
+func (b *bitReader) peek16() uint16 {
+	if b.n < 16 {
+		b.fill()
+	}
+	return uint16(b.acc >> 48)
+}
+
+func (b *bitReader) consume(n uint) {
+	b.acc <<= n
+	b.n -= n
+}
+
+func (b *bitReader) receive(n uint) int {
+	if b.n < n {
+		b.fill()
+	}
+	v := int(b.acc >> (64 - n))
+	b.consume(n)
+	return v
+}
+
+//restart discards any buffered bits and skips over the next RSTn marker.
+func (b *bitReader) restart() error {
+	b.acc, b.n, b.marker, b.pad = 0, 0, false, 0
+	for b.pos+1 < len(b.buf) {
+		if b.buf[b.pos] == 0xff && b.buf[b.pos+1]&0xf8 == markerRST {
+			b.pos += 2
+			return nil
+		}
+		b.pos++
+	}
+	return errLJPEGTruncated
+}
+
+//diff decodes a single difference value, T.81 Table H.2.
+func (b *bitReader) diff(h *huffmanTable) (int, error) {
+	e := h.lookup[b.peek16()]
+	l := uint(e >> 8)
+	if l == 0 {
+		return 0, errLJPEGCode
+	}
+	b.consume(l)
+
+	s := uint(e & 0xff)
+	switch {
+	case s == 0:
+		return 0, nil
+	case s == 16:
+		return 32768, nil
+	case s > 16:
+		return 0, errLJPEGCode
+	}
+
+	v := b.receive(s)
+	if v < 1<<(s-1) {
+		v -= 1<<s - 1
+	}
+	return v, nil
+}
+
+//decodeLJPEG decodes a single scan lossless JPEG image.
+func decodeLJPEG(buf []byte) (*ljpegImage, error) {
+	if len(buf) < 2 || buf[0] != 0xff || buf[1] != markerSOI {
+		return nil, errLJPEGMarker
+	}
+
+	var (
+		img             ljpegImage
+		tables          [4]*huffmanTable
+		compIDs         []uint8
+		restartInterval int
+	)
+
+	pos := 2
+	for {
+		for pos < len(buf) && buf[pos] != 0xff {
+			pos++
+		}
+		for pos < len(buf) && buf[pos] == 0xff {
+			pos++
+		}
+		if pos+2 >= len(buf) {
+			return nil, errLJPEGTruncated
+		}
+		marker := buf[pos]
+		pos++
+
+		if marker == markerEOI {
+			return nil, errLJPEGMarker
+		}
+
+		length := int(binary.BigEndian.Uint16(buf[pos:]))
+		if length < 2 || pos+length > len(buf) {
+			return nil, errLJPEGTruncated
+		}
+		segment := buf[pos+2 : pos+length]
+		pos += length
+
+		switch marker {
+		case markerDHT:
+			for len(segment) > 0 {
+				if len(segment) < 17 {
+					return nil, errLJPEGHuffman
+				}
+				class, id := segment[0]>>4, segment[0]&0x0f
+				if class != 0 || id > 3 {
+					return nil, errLJPEGHuffman
+				}
+				var counts [16]uint8
+				copy(counts[:], segment[1:17])
+				total := 0
+				for _, c := range counts {
+					total += int(c)
+				}
+				if 17+total > len(segment) {
+					return nil, errLJPEGHuffman
+				}
+				h, err := newHuffmanTable(counts, segment[17:17+total])
+				if err != nil {
+					return nil, err
+				}
+				tables[id] = h
+				segment = segment[17+total:]
+			}
+		case markerSOF:
+			if len(segment) < 6 {
+				return nil, errLJPEGFrame
+			}
+			img.precision = int(segment[0])
+			img.height = int(binary.BigEndian.Uint16(segment[1:]))
+			img.width = int(binary.BigEndian.Uint16(segment[3:]))
+			img.components = int(segment[5])
+			if img.precision < 2 || img.precision > 16 || img.components < 1 || img.components > 4 || len(segment) < 6+3*img.components {
+				return nil, errLJPEGFrame
+			}
+			compIDs = make([]uint8, img.components)
+			for i := range compIDs {
+				compIDs[i] = segment[6+3*i]
+			}
+		case markerDRI:
+			if len(segment) < 2 {
+				return nil, errLJPEGMarker
+			}
+			restartInterval = int(binary.BigEndian.Uint16(segment))
+		case markerSOS:
+			if compIDs == nil || len(segment) < 1 || int(segment[0]) != img.components || len(segment) < 4+2*img.components {
+				return nil, errLJPEGFrame
+			}
+			scanTables := make([]*huffmanTable, img.components)
+			for i := range scanTables {
+				if segment[1+2*i] != compIDs[i] {
+					return nil, errLJPEGFrame
+				}
+				scanTables[i] = tables[segment[2+2*i]>>4&0x03]
+				if scanTables[i] == nil {
+					return nil, errLJPEGHuffman
+				}
+			}
+			params := segment[1+2*img.components:]
+			predictor, pointTransform := int(params[0]), int(params[2]&0x0f)
+			if predictor < 1 || predictor > 7 || pointTransform >= img.precision {
+				return nil, errLJPEGFrame
+			}
+
+			if err := img.decodeScan(buf[pos:], scanTables, predictor, pointTransform, restartInterval); err != nil {
+				return nil, err
+			}
+			return &img, nil
+		case markerSOF - 3, markerSOF - 2, markerSOF - 1, markerSOF + 2, markerSOF + 3, markerSOF + 4:
+			//Any other frame type than lossless Huffman coded.
+			return nil, errLJPEGFrame
+		}
+	}
+}
+
+//decodeScan decodes the entropy coded data, T.81 Annex H.
+func (img *ljpegImage) decodeScan(data []byte, tables []*huffmanTable, predictor, pointTransform, restartInterval int) error {
+	if img.width == 0 || img.height == 0 {
+		return errLJPEGFrame
+	}
+	//Restarts are only supported at the start of a line, which is what every encoder does in practice.
+	if restartInterval > 0 && restartInterval%img.width != 0 {
+		return errLJPEGFrame
+	}
+
+	comps := img.components
+	rowLength := img.width * comps
+	//Every sample takes at least a single bit, don't allocate for samples that can't be in the stream.
+	if rowLength*img.height > 8*len(data)+64 {
+		return errLJPEGTruncated
+	}
+	img.pix = make([]uint16, rowLength*img.height)
+	initial := 1 << uint(img.precision-pointTransform-1)
+	mask := 1<<uint(img.precision-pointTransform) - 1
+
+	br := bitReader{buf: data}
+	firstRow := true
+	for y := 0; y < img.height; y++ {
+		if restartInterval > 0 && y > 0 && (y*img.width)%restartInterval == 0 {
+			if err := br.restart(); err != nil {
+				return err
+			}
+			firstRow = true
+		}
+
+		cur := img.pix[y*rowLength : (y+1)*rowLength]
+		var prev []uint16
+		if !firstRow {
+			prev = img.pix[(y-1)*rowLength : y*rowLength]
+		}
+
+		for x := 0; x < img.width; x++ {
+			for c := 0; c < comps; c++ {
+				diff, err := br.diff(tables[c])
+				if err != nil {
+					return err
+				}
+
+				i := x*comps + c
+				var pred int
+				switch {
+				case firstRow && x == 0:
+					pred = initial
+				case firstRow:
+					pred = int(cur[i-comps])
+				case x == 0:
+					pred = int(prev[i])
+				default:
+					ra, rb, rc := int(cur[i-comps]), int(prev[i]), int(prev[i-comps])
+					switch predictor {
+					case 1:
+						pred = ra
+					case 2:
+						pred = rb
+					case 3:
+						pred = rc
+					case 4:
+						pred = ra + rb - rc
+					case 5:
+						pred = ra + (rb-rc)>>1
+					case 6:
+						pred = rb + (ra-rc)>>1
+					case 7:
+						pred = (ra + rb) >> 1
+					}
+				}
+				cur[i] = uint16((pred + diff) & mask)
+			}
+		}
+		firstRow = false
+
+		if br.overrun() {
+			return errLJPEGTruncated
+		}
+	}
+
+	if pointTransform > 0 {
+		for i := range img.pix {
+			img.pix[i] <<= uint(pointTransform)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package arw
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+func TestDecodeLJPEG(t *testing.T) {
+	for _, components := range []int{1, 2, 4} {
+		const width, height, precision = 13, 7, 14
+		pix := make([]uint16, width*height*components)
+		for i := range pix {
+			pix[i] = uint16(rand.Intn(1 << precision))
+		}
+
+		img, err := decodeLJPEG(arwtest.EncodeLJPEG(pix, width, height, components, precision))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.width != width || img.height != height || img.components != components || img.precision != precision {
+			t.Fatalf("Unexpected frame %+v", img)
+		}
+		for i := range pix {
+			if img.pix[i] != pix[i] {
+				t.Fatalf("%v components, sample %v: expected %v, got %v", components, i, pix[i], img.pix[i])
+			}
+		}
+	}
+}
+
+func TestDecodeLJPEGTruncated(t *testing.T) {
+	pix := make([]uint16, 64*64)
+	for i := range pix {
+		pix[i] = uint16(rand.Intn(1 << 14))
+	}
+	stream := arwtest.EncodeLJPEG(pix, 64, 64, 1, 14)
+
+	if _, err := decodeLJPEG(stream[:len(stream)/2]); err == nil {
+		t.Error("Expected an error for a truncated stream")
+	}
+}
+
+func TestUnpackLosslessTiles(t *testing.T) {
+	//A 6x4 mosaic stored as 4x2 tiles in Sony's layout, each 2x2 block is a single four component sample.
+	const width, height, tileWidth, tileLength = 6, 4, 4, 2
+	mosaic := make([]uint16, width*height)
+	for i := range mosaic {
+		mosaic[i] = uint16(i * 100)
+	}
+
+	var file []byte
+	var rw rawDetails
+	rw.width, rw.height = width, height
+	rw.tileWidth, rw.tileLength = tileWidth, tileLength
+	for top := 0; top < height; top += tileLength {
+		for left := 0; left < width; left += tileWidth {
+			var tile []uint16
+			for y := top; y < top+tileLength; y += 2 {
+				for x := left; x < left+tileWidth; x += 2 {
+					at := func(y, x int) uint16 {
+						if x >= width {
+							return 0
+						}
+						return mosaic[y*width+x]
+					}
+					tile = append(tile, at(y, x), at(y, x+1), at(y+1, x), at(y+1, x+1))
+				}
+			}
+			stream := arwtest.EncodeLJPEG(tile, tileWidth/2, tileLength/2, 4, 14)
+			rw.tileOffsets = append(rw.tileOffsets, uint32(len(file)))
+			rw.tileCounts = append(rw.tileCounts, uint32(len(stream)))
+			file = append(file, stream...)
+		}
+	}
+
+	data, precision, err := unpackLossless(bytes.NewReader(file), rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if precision != 14 {
+		t.Error("Expected 14 bit precision, got:", precision)
+	}
+	for i := range mosaic {
+		if data[i] != mosaic[i] {
+			t.Errorf("Pixel %v: expected %v, got %v", i, mosaic[i], data[i])
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package arw
+
+//TIFF uses its own flavour of LZW, compress/lzw can't be used: codes are packed MSB first and the code width
+//grows one code early, TIFF 6.0 Section 13.
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwFirst    = 258
+	lzwMaxWidth = 12
+	//lzwTableFull is where the table is reset, the last code is never used so decoders can't overflow.
+	lzwTableFull = 1<<lzwMaxWidth - 2
+)
+
+//msbWriter packs codes of varying width in to bytes starting at the most significant bit.
+type msbWriter struct {
+	buf   []byte
+	bits  uint32
+	nbits uint
+}
+
+func (w *msbWriter) write(code uint32, width uint) {
+	w.bits = w.bits<<width | code
+	w.nbits += width
+	for w.nbits >= 8 {
+		w.nbits -= 8
+		w.buf = append(w.buf, byte(w.bits>>w.nbits))
+	}
+	w.bits &= 1<<w.nbits - 1
+}
+
+func (w *msbWriter) flush() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.bits<<(8-w.nbits)))
+		w.bits, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+//lzwEncode compresses data the way TIFF LZW decoders expect it.
+func lzwEncode(data []byte) []byte {
+	var w msbWriter
+	width := uint(9)
+	next := uint32(lzwFirst)
+	//table maps a code followed by a byte to the code of the longer string.
+	table := make(map[uint32]uint32)
+	w.write(lzwClear, width)
+
+	//added accounts for the code which was just written, the decoder adds its table entry one code later
+	//but has to read the following code at the same width.
+	added := func() {
+		next++
+		if next == lzwTableFull {
+			w.write(lzwClear, width)
+			table = make(map[uint32]uint32)
+			next, width = lzwFirst, 9
+		} else if next > 1<<width-1 {
+			width++
+		}
+	}
+
+	if len(data) == 0 {
+		w.write(lzwEOI, width)
+		return w.flush()
+	}
+	prefix := uint32(data[0])
+	for _, c := range data[1:] {
+		key := prefix<<8 | uint32(c)
+		if code, ok := table[key]; ok {
+			prefix = code
+			continue
+		}
+		w.write(prefix, width)
+		table[key] = next
+		added()
+		prefix = uint32(c)
+	}
+	w.write(prefix, width)
+	added()
+	w.write(lzwEOI, width)
+	return w.flush()
+}
@@ -1,90 +1,120 @@
 package arw
 
-import (
-	"fmt"
-	"github.com/gonum/matrix/mat64"
-	"log"
-)
+import "math"
 
-//Helper function for createToneCurve which generates a Van der Monde matrix.
-func vanDerMonde(a []float64, degree int) *mat64.Dense {
-	x := mat64.NewDense(len(a), degree+1, nil)
-	for i := range a {
-		for j, p := 0, 1.; j <= degree; j, p = j+1, p*a[i] {
-			x.Set(i, j, p)
-		}
+//toneCurve maps linear 14 bit values through the Sony provided tone curve.
+//Every decode creates its own since each file carries its own curve.
+type toneCurve [0x4000]uint16
+
+//gamma applies the curve to a value between 0 and 1, values outside of that range are clipped.
+func (t *toneCurve) gamma(x float64) float64 {
+	switch {
+	case x <= 0:
+		return float64(t[0]) / 0x3fff
+	case x >= 1:
+		return float64(t[len(t)-1]) / 0x3fff
 	}
-	return x
+	return float64(t[int(x*0x3fff+0.5)]) / 0x3fff
 }
 
-//xFactors are coefficients which are used to map incoming data to the Sony provided tone curve.
-var xFactors [6]float64
-var sRGBFactors [3]float64
-
-func sRGB(x float64) float64 {
-	x2 := sRGBFactors[2] * x * x
-	x1 := sRGBFactors[1] * x
-	x0 := sRGBFactors[0]
-	val := x2 + x1 + x0
-	return val
-}
+//The gamma curve points are spread evenly over the input range and normalised to 1.
+//We interpolate them with a monotone cubic so the curve can't overshoot between the points.
+func createToneCurve(curve [6]float64) *toneCurve {
+	var t toneCurve
+	if !(curve[5] > 0) {
+		//Without a curve the points are all zero, or NaN once normalised, and the data is left linear.
+		for i := range t {
+			t[i] = uint16(i)
+		}
+		return &t
+	}
 
-func gamma(x float64) float64 {
-	if x > 1 {
-		panic("This shouldn't be happening!" + fmt.Sprint("X=", x))
+	const step = 0.2
+	tangents := monotoneTangents(curve, step)
+	for i := range t {
+		x := float64(i) / 0x3fff
+		k := int(x / step)
+		if k >= len(curve)-1 {
+			k = len(curve) - 2
+		}
+		y := hermite(curve[k], curve[k+1], tangents[k]*step, tangents[k+1]*step, x/step-float64(k))
+		t[i] = uint16(math.Max(0, math.Min(1, y))*0x3fff + 0.5)
 	}
-	x5 := xFactors[5] * x * x * x * x * x
-	x4 := xFactors[4] * x * x * x * x
-	x3 := xFactors[3] * x * x * x
-	x2 := xFactors[2] * x * x
-	x1 := xFactors[1] * x
-	x0 := xFactors[0] * 1
-	val := x5 + x4 + x3 + x2 + x1 + x0 //The negative signs are already in the numbers
-	return val
+	return &t
 }
 
-//The gamma curve points are in a 14 bit space space where we draw a curve that goes through the points.
-func createToneCurve(curve [6]float64) {
-	x := []float64{0, 0.2, 0.4, 0.6, 0.8, 1}
-	y := []float64{float64(curve[0]), float64(curve[1]), float64(curve[2]), float64(curve[3]), curve[4], curve[5]}
-	const degree = 5
-
-	a := vanDerMonde(x, degree)
-	b := mat64.NewDense(len(y), 1, y)
-	c := mat64.NewDense(degree+1, 1, nil)
+//monotoneTangents returns the tangents at evenly spaced points which keep a cubic Hermite spline monotone between them,
+//following Fritsch and Carlson.
+func monotoneTangents(y [6]float64, step float64) [6]float64 {
+	var secants [5]float64
+	for k := range secants {
+		secants[k] = (y[k+1] - y[k]) / step
+	}
 
-	qr := new(mat64.QR)
-	qr.Factorize(a)
+	var m [6]float64
+	m[0], m[5] = secants[0], secants[4]
+	for k := 1; k < 5; k++ {
+		if secants[k-1]*secants[k] > 0 {
+			m[k] = (secants[k-1] + secants[k]) / 2
+		}
+	}
 
-	if err := c.SolveQR(qr, false, b); err != nil {
-		log.Println(err)
+	for k, d := range secants {
+		if d == 0 {
+			m[k], m[k+1] = 0, 0
+			continue
+		}
+		a, b := m[k]/d, m[k+1]/d
+		if r := a*a + b*b; r > 9 {
+			tau := 3 / math.Sqrt(r)
+			m[k], m[k+1] = tau*a*d, tau*b*d
+		}
 	}
+	return m
+}
 
-	xFactors[5] = c.At(5, 0)
-	xFactors[4] = c.At(4, 0)
-	xFactors[3] = c.At(3, 0)
-	xFactors[2] = c.At(2, 0)
-	xFactors[1] = c.At(1, 0)
-	xFactors[0] = c.At(0, 0)
+//hermite evaluates the cubic between y0 and y1 with tangents m0 and m1 at t between 0 and 1.
+func hermite(y0, y1, m0, m1, t float64) float64 {
+	t2 := t * t
+	t3 := t2 * t
+	return (2*t3-3*t2+1)*y0 + (t3-2*t2+t)*m0 + (-2*t3+3*t2)*y1 + (t3-t2)*m1
 }
 
-func createSRGBCurve() {
-	x := []float64{0, 0.50, 1}
-	y := []float64{0, 0x2fff, 0x3fff}
-	const degree = 2
+//sonyCurveLUT builds the expansion from 11 bit CRAW values to 14 bit linear values.
+//The curve points mark where the slope doubles, this is the same construction dcraw uses
+//except that we keep the full 14 bit range.
+func sonyCurveLUT(curve [5]uint16) *[0x800]uint16 {
+	var lut [0x800]uint16
 
-	a := vanDerMonde(x, degree)
-	b := mat64.NewDense(len(y), 1, y)
-	c := mat64.NewDense(degree+1, 1, nil)
+	if curve[0] == 0 {
+		//No curve was found so we assume the data to be linear.
+		for i := range lut {
+			lut[i] = uint16(i << 3)
+		}
+		return &lut
+	}
 
-	qr := new(mat64.QR)
-	qr.Factorize(a)
+	var points [6]int
+	for i := range curve {
+		points[i+1] = int(curve[i]>>2) & 0xfff
+	}
 
-	if err := c.SolveQR(qr, false, b); err != nil {
-		log.Println(err)
+	var expanded [0x1000]int
+	for i := range expanded {
+		expanded[i] = i
+	}
+	for i := 0; i < 5; i++ {
+		for j := points[i] + 1; j <= points[i+1]; j++ {
+			expanded[j] = expanded[j-1] + 1<<uint(i)
+		}
 	}
 
-	sRGBFactors[2] = c.At(2, 0)
-	sRGBFactors[1] = c.At(1, 0)
-	sRGBFactors[0] = c.At(0, 0)
+	for i := range lut {
+		v := expanded[i<<1]
+		if v > 0x3fff {
+			v = 0x3fff
+		}
+		lut[i] = uint16(v)
+	}
+	return &lut
 }
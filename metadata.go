@@ -0,0 +1,174 @@
+package arw
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Program is the class of program used by the camera to set exposure, CIPA DC-008-2012 Chapter 4.6.5.
+//go:generate stringer -type=Program
+type Program uint16
+
+const (
+	ProgramNotDefined Program = iota
+	ProgramManual
+	ProgramNormal
+	ProgramAperturePriority
+	ProgramShutterPriority
+	ProgramCreative
+	ProgramAction
+	ProgramPortrait
+	ProgramLandscape
+)
+
+//Metering is the metering mode used by the camera, CIPA DC-008-2012 Chapter 4.6.5.
+//go:generate stringer -type=Metering
+type Metering uint16
+
+const (
+	MeteringUnknown Metering = iota
+	MeteringAverage
+	MeteringCenterWeightedAverage
+	MeteringSpot
+	MeteringMultiSpot
+	MeteringPattern
+	MeteringPartial
+	MeteringOther Metering = 255
+)
+
+//FlashStatus holds the flash status bits, CIPA DC-008-2012 Chapter 4.6.5.
+type FlashStatus uint16
+
+//Fired reports whether the flash fired.
+func (f FlashStatus) Fired() bool {
+	return f&0x1 != 0
+}
+
+//Metadata holds the shooting information of an ARW file taken from IFD0, the Exif IFD and the raw SubIFD.
+type Metadata struct {
+	Make         string
+	Model        string
+	SerialNumber string
+	LensModel    string
+	//LensSpec holds the minimum and maximum focal length followed by the minimum f-number at both.
+	LensSpec [4]float32
+
+	//CaptureTime is taken from DateTimeOriginal, OffsetTimeOriginal and SubsecTimeOriginal.
+	//Without a recorded offset the time is in the local time zone.
+	CaptureTime           time.Time
+	ExposureTime          time.Duration
+	FNumber               float32
+	ISO                   uint16
+	ExposureProgram       Program
+	MeteringMode          Metering
+	Flash                 FlashStatus
+	FocalLength           float32
+	FocalLengthIn35mmFilm uint16
+
+	Width    int
+	Height   int
+	BitDepth int
+	//WhiteLevel holds the R, G and B values at which the sensor saturates, in the scale of the raw data.
+	WhiteLevel [3]uint16
+	//Orientation is how the image has to be turned to be upright, see Options.Upright.
+	Orientation ImageOrientation
+	RawFormat   RawFormat
+}
+
+//ReadMetadata reads the shooting information from an ARW file.
+func ReadMetadata(r io.ReadSeeker) (*Metadata, error) {
+	rw, err := extractDetails(r)
+	if err != nil {
+		return nil, err
+	}
+
+	//A date left blank or garbled by the camera is common and shouldn't hide everything else, CaptureTime stays zero.
+	captured, _ := captureTime(rw.dateTimeOriginal, rw.offsetTimeOriginal, rw.subsecTimeOriginal)
+
+	return &Metadata{
+		Make:         rw.make,
+		Model:        rw.model,
+		SerialNumber: rw.serial,
+		LensModel:    rw.lensModel,
+		LensSpec:     rw.lensSpec,
+
+		CaptureTime:           captured,
+		ExposureTime:          time.Duration(float64(rw.shutter) * float64(time.Second)),
+		FNumber:               rw.aperture,
+		ISO:                   rw.iso,
+		ExposureProgram:       Program(rw.exposureProgram),
+		MeteringMode:          Metering(rw.meteringMode),
+		Flash:                 FlashStatus(rw.flash),
+		FocalLength:           rw.focalLength,
+		FocalLengthIn35mmFilm: rw.focalLength35,
+
+		Width:       int(rw.width),
+		Height:      int(rw.height),
+		BitDepth:    int(rw.bitDepth),
+		WhiteLevel:  whiteLevels(rw.whiteLevel, rw.maxValue()),
+		Orientation: rw.orientation,
+		RawFormat:   rw.rawType,
+	}, nil
+}
+
+//exifTimeLayout is the format of all Exif DateTime fields.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+//captureTime combines the Exif date, offset and subsecond fields in to a single time.
+//An unset date results in the zero time, subseconds which aren't a number are left out.
+func captureTime(date, offset, subsec string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if date == "" || strings.HasPrefix(date, "0000") {
+		return time.Time{}, nil
+	}
+
+	//Cameras without a time zone set write a blank offset such as "   :  ", the time is then taken to be local.
+	loc := time.Local
+	if zone, err := parseOffset(strings.TrimSpace(offset)); err == nil {
+		loc = zone
+	}
+
+	t, err := time.ParseInLocation(exifTimeLayout, date, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if subsec = strings.TrimSpace(subsec); subsec != "" {
+		if len(subsec) > 9 {
+			subsec = subsec[:9]
+		}
+		if fraction, err := strconv.ParseUint(subsec, 10, 32); err == nil {
+			nsec := int64(fraction)
+			for i := len(subsec); i < 9; i++ {
+				nsec *= 10
+			}
+			t = t.Add(time.Duration(nsec))
+		}
+	}
+
+	return t, nil
+}
+
+//parseOffset parses an Exif time offset such as "+01:00".
+func parseOffset(offset string) (*time.Location, error) {
+	if len(offset) != 6 || (offset[0] != '+' && offset[0] != '-') || offset[3] != ':' {
+		return nil, errors.New("invalid time offset: " + offset)
+	}
+	hours, err := strconv.Atoi(offset[1:3])
+	if err != nil {
+		return nil, err
+	}
+	minutes, err := strconv.Atoi(offset[4:6])
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := hours*60*60 + minutes*60
+	if offset[0] == '-' {
+		seconds = -seconds
+	}
+	return time.FixedZone(offset, seconds), nil
+}
@@ -2,16 +2,17 @@ package arw
 
 import (
 	"bytes"
-	"os"
+	"encoding/binary"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/Soreil/arw/internal/arwtest"
 )
 
 func TestMetadata(t *testing.T) {
-	samplename := samples[raw14][1]
-	testARW, err := os.Open(samplename + ".ARW")
-	if err != nil {
-		t.Error(err)
-	}
+	samplename := samples[Raw14][1]
+	testARW := openSample(t, samplename)
 
 	header, err := ParseHeader(testARW)
 	if err != nil {
@@ -129,7 +130,10 @@ func TestMetadata(t *testing.T) {
 					sr2key[0] = byte((key) & 0xff)
 				}
 			}
-			buf := DecryptSR2(testARW, sr2offset, sr2length)
+			buf, err := DecryptSR2(testARW, sr2offset, sr2length)
+			if err != nil {
+				t.Fatal(err)
+			}
 			br := bytes.NewReader(buf)
 
 			sr2, err := ExtractMetaData(br, 0, 0)
@@ -155,11 +159,8 @@ func TestMetadata(t *testing.T) {
 }
 
 func TestNestedHeader(t *testing.T) {
-	samplename := samples[raw14][0]
-	testARW, err := os.Open(samplename + ".ARW")
-	if err != nil {
-		t.Error(err)
-	}
+	samplename := samples[Raw14][0]
+	testARW := openSample(t, samplename)
 
 	meta, err := ExtractMetaData(testARW, 52082, 0)
 	if err != nil {
@@ -191,7 +192,10 @@ func TestNestedHeader(t *testing.T) {
 
 	t.Logf("SR2len: %v SR2off: %v SR2key: %v\n", sr2length, sr2offset, sr2key)
 
-	buf := DecryptSR2(testARW, sr2offset, sr2length)
+	buf, err := DecryptSR2(testARW, sr2offset, sr2length)
+	if err != nil {
+		t.Fatal(err)
+	}
 	br := bytes.NewReader(buf)
 
 	meta, err = ExtractMetaData(br, 0, 0)
@@ -204,3 +208,364 @@ func TestNestedHeader(t *testing.T) {
 		t.Logf("%+v\n", v)
 	}
 }
+
+func TestReadMetadata(t *testing.T) {
+	samplename := samples[Raw14][0]
+	testARW := openSample(t, samplename)
+	defer testARW.Close()
+
+	meta, err := ReadMetadata(testARW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v\n", meta)
+
+	if meta.Make != "SONY" {
+		t.Error("Expected SONY as make, got:", meta.Make)
+	}
+	if meta.CaptureTime.IsZero() {
+		t.Error("Expected a capture time")
+	}
+	if meta.Width == 0 || meta.Height == 0 {
+		t.Error("Expected raw dimensions")
+	}
+}
+
+func TestReadMetadataSynthetic(t *testing.T) {
+	file := syntheticFile(arwtest.CRAW)
+	file.Make, file.Model = "SONY", "ILCE-7RM3"
+	file.Exif = arwtest.Exif{
+		ExposureTime:          [2]uint32{1, 250},
+		FNumber:               [2]uint32{56, 10},
+		FocalLength:           [2]uint32{350, 10},
+		ISO:                   400,
+		FocalLengthIn35mmFilm: 35,
+		ExposureProgram:       uint16(ProgramAperturePriority),
+		MeteringMode:          uint16(MeteringPattern),
+		Flash:                 0x10,
+		DateTimeOriginal:      "2018:09:07 14:03:21",
+		OffsetTimeOriginal:    "+02:00",
+		SubsecTimeOriginal:    "5",
+		LensModel:             "FE 35mm F1.8",
+		BodySerialNumber:      "01234567",
+		LensSpecification:     [4][2]uint32{{35, 1}, {35, 1}, {18, 10}, {18, 10}},
+	}
+
+	meta, err := ReadMetadata(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Metadata{
+		Make:                  "SONY",
+		Model:                 "ILCE-7RM3",
+		SerialNumber:          "01234567",
+		LensModel:             "FE 35mm F1.8",
+		LensSpec:              [4]float32{35, 35, 1.8, 1.8},
+		CaptureTime:           time.Date(2018, 9, 7, 14, 3, 21, 5e8, time.FixedZone("+02:00", 2*60*60)),
+		ExposureTime:          4 * time.Millisecond,
+		FNumber:               5.6,
+		ISO:                   400,
+		ExposureProgram:       ProgramAperturePriority,
+		MeteringMode:          MeteringPattern,
+		Flash:                 0x10,
+		FocalLength:           35,
+		FocalLengthIn35mmFilm: 35,
+		Width:                 file.Width,
+		Height:                file.Height,
+		BitDepth:              8,
+		WhiteLevel:            [3]uint16{0x3fff, 0x3fff, 0x3fff},
+		Orientation:           OrientationTopLeft,
+		RawFormat:             CRAW,
+	}
+	if !meta.CaptureTime.Equal(expected.CaptureTime) {
+		t.Error("Expected capture time", expected.CaptureTime, "got:", meta.CaptureTime)
+	}
+	meta.CaptureTime = expected.CaptureTime
+	if *meta != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *meta)
+	}
+}
+
+func TestCaptureTime(t *testing.T) {
+	captured, err := captureTime("2018:09:07 14:03:21", "+02:00", "25")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := time.Date(2018, 9, 7, 12, 3, 21, 250000000, time.UTC)
+	if !captured.Equal(expected) {
+		t.Errorf("Expected %v, got %v", expected, captured)
+	}
+	if _, offset := captured.Zone(); offset != 2*60*60 {
+		t.Error("Expected a two hour offset, got:", offset)
+	}
+
+	if captured, err := captureTime("0000:00:00 00:00:00", "", ""); err != nil || !captured.IsZero() {
+		t.Error("Expected an unset date to give the zero time, got:", captured, err)
+	}
+
+	//A blank or malformed offset falls back to local time.
+	for _, offset := range []string{"   :  ", "02:00", "+xx:00"} {
+		captured, err := captureTime("2018:09:07 14:03:21", offset, "")
+		if err != nil {
+			t.Fatalf("Offset %q: %v", offset, err)
+		}
+		if local := time.Date(2018, 9, 7, 14, 3, 21, 0, time.Local); !captured.Equal(local) || captured.Location() != time.Local {
+			t.Errorf("Offset %q: expected %v, got %v", offset, local, captured)
+		}
+	}
+
+	if captured, err := captureTime("2018:09:07 14:03:21", "+02:00", "x5"); err != nil || captured.Nanosecond() != 0 {
+		t.Error("Expected malformed subseconds to be left out, got:", captured, err)
+	}
+	if captured, err := captureTime("                   ", "", ""); err != nil || !captured.IsZero() {
+		t.Error("Expected a blank date to give the zero time, got:", captured, err)
+	}
+}
+
+func TestReadMetadataBadDate(t *testing.T) {
+	//A date the camera garbled leaves the capture time unset but the rest of the metadata is still read.
+	for _, date := range []string{"                   ", "2018:13:45 99:99:99", "not a date"} {
+		file := syntheticFile(arwtest.Raw14)
+		file.Make = "SONY"
+		file.Exif = arwtest.Exif{ISO: 400, DateTimeOriginal: date, SubsecTimeOriginal: "x"}
+
+		meta, err := ReadMetadata(bytes.NewReader(file.Bytes()))
+		if err != nil {
+			t.Fatalf("Date %q: %v", date, err)
+		}
+		if !meta.CaptureTime.IsZero() || meta.Make != "SONY" || meta.ISO != 400 {
+			t.Errorf("Date %q: expected the metadata without a capture time, got %+v", date, meta)
+		}
+	}
+}
+
+//tinyTIFF writes a TIFF header followed by a single IFD holding ImageWidth as a SHORT and ImageHeight as a LONG.
+func tinyTIFF(order binary.ByteOrder, width uint16, height uint32) []byte {
+	var buf bytes.Buffer
+	if order == binary.BigEndian {
+		buf.WriteString("MM")
+	} else {
+		buf.WriteString("II")
+	}
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+
+	short := make([]byte, 4)
+	order.PutUint16(short, width)
+	binary.Write(&buf, order, uint16(2))
+	binary.Write(&buf, order, IFDFIA{ImageWidth, SHORT, 1, order.Uint32(short)})
+	binary.Write(&buf, order, IFDFIA{ImageHeight, LONG, 1, height})
+	binary.Write(&buf, order, uint32(0))
+	return buf.Bytes()
+}
+
+//shortARW writes a minimal ARW in order whose SHORT fields are stored inline, Sony only writes little endian files
+//but nothing in TIFF stops another tool from rewriting one in big endian.
+func shortARW(order binary.ByteOrder) []byte {
+	short := func(tag IFDtag, v uint16) IFDFIA {
+		inline := make([]byte, 4)
+		order.PutUint16(inline, v)
+		return IFDFIA{tag, SHORT, 1, order.Uint32(inline)}
+	}
+	const (
+		rawOffset  = 8 + 2 + 3*12 + 4
+		exifOffset = rawOffset + 2 + 6*12 + 4
+		dataOffset = exifOffset + 2 + 2*12 + 4
+	)
+	const width, height = 64, 6
+
+	var buf bytes.Buffer
+	if order == binary.BigEndian {
+		buf.WriteString("MM")
+	} else {
+		buf.WriteString("II")
+	}
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+
+	binary.Write(&buf, order, uint16(3))
+	binary.Write(&buf, order, short(Orientation, uint16(OrientationRightTop)))
+	binary.Write(&buf, order, IFDFIA{SubIFDs, LONG, 1, rawOffset})
+	binary.Write(&buf, order, IFDFIA{ExifTag, LONG, 1, exifOffset})
+	binary.Write(&buf, order, uint32(0))
+
+	binary.Write(&buf, order, uint16(6))
+	binary.Write(&buf, order, short(ImageWidth, width))
+	binary.Write(&buf, order, short(ImageHeight, height))
+	binary.Write(&buf, order, short(BitsPerSample, 14))
+	binary.Write(&buf, order, IFDFIA{StripOffsets, LONG, 1, dataOffset})
+	binary.Write(&buf, order, IFDFIA{StripByteCounts, LONG, 1, width * height * 2})
+	binary.Write(&buf, order, short(SonyRawFileType, uint16(Raw14)))
+	binary.Write(&buf, order, uint32(0))
+
+	binary.Write(&buf, order, uint16(2))
+	binary.Write(&buf, order, short(ISOSpeedRatings, 400))
+	binary.Write(&buf, order, short(MeteringMode, 5))
+	binary.Write(&buf, order, uint32(0))
+
+	buf.Write(make([]byte, width*height*2))
+	return buf.Bytes()
+}
+
+func TestInlineShorts(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		r := bytes.NewReader(tinyTIFF(order, 6000, 4000))
+		meta, err := ExtractMetaData(r, 8, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if width := first(meta.FIAvals[0]); width != 6000 {
+			t.Errorf("%v: expected width 6000, got %v", order, width)
+		}
+
+		rw, err := extractDetails(bytes.NewReader(shortARW(order)))
+		if err != nil {
+			t.Fatal(order, err)
+		}
+		if rw.width != 64 || rw.height != 6 || rw.bitDepth != 14 || rw.rawType != Raw14 {
+			t.Errorf("%v: expected a 64x6 14 bit Raw14 image, got %vx%v %v bit %v", order, rw.width, rw.height, rw.bitDepth, rw.rawType)
+		}
+		if rw.orientation != OrientationRightTop || rw.iso != 400 || rw.meteringMode != 5 {
+			t.Errorf("%v: expected %v, ISO 400 and metering mode 5, got %v, %v and %v", order, OrientationRightTop, rw.orientation, rw.iso, rw.meteringMode)
+		}
+	}
+}
+
+func TestMixedByteOrderConcurrent(t *testing.T) {
+	files := map[binary.ByteOrder][]byte{
+		binary.LittleEndian: tinyTIFF(binary.LittleEndian, 6000, 4000),
+		binary.BigEndian:    tinyTIFF(binary.BigEndian, 6000, 4000),
+	}
+
+	var wg sync.WaitGroup
+	for order, file := range files {
+		wg.Add(1)
+		go func(order binary.ByteOrder, file []byte) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				r := bytes.NewReader(file)
+				header, err := ParseHeader(r)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if header.Order() != order {
+					t.Errorf("Expected %v, got %v", order, header.Order())
+					return
+				}
+
+				meta, err := ExtractMetaDataWithOrder(r, header.Order(), int64(header.Offset), 0)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if width := (*meta.FIAvals[0].short)[0]; width != 6000 {
+					t.Errorf("%v: expected width 6000, got %v", order, width)
+					return
+				}
+				if height := (*meta.FIAvals[1].long)[0]; height != 4000 {
+					t.Errorf("%v: expected height 4000, got %v", order, height)
+					return
+				}
+			}
+		}(order, file)
+	}
+	wg.Wait()
+}
+
+func TestExtractMetaDataOrderFromHeader(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		r := bytes.NewReader(tinyTIFF(order, 6000, 4000))
+		//The offset is relative to the current position, the header is still read from the start of the file.
+		if _, err := r.Seek(4, 0); err != nil {
+			t.Fatal(err)
+		}
+		meta, err := ExtractMetaData(r, 4, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if width := (*meta.FIAvals[0].short)[0]; width != 6000 {
+			t.Errorf("%v: expected width 6000, got %v", order, width)
+		}
+		if height := (*meta.FIAvals[1].long)[0]; height != 4000 {
+			t.Errorf("%v: expected height 4000, got %v", order, height)
+		}
+	}
+
+	//A decrypted SR2 block is a bare IFD, which is little endian.
+	ifd := tinyTIFF(binary.LittleEndian, 6000, 4000)[8:]
+	meta, err := ExtractMetaData(bytes.NewReader(ifd), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width := (*meta.FIAvals[0].short)[0]; width != 6000 {
+		t.Error("Expected width 6000 without a header, got:", width)
+	}
+}
+
+func TestInlineLong(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var buf bytes.Buffer
+		binary.Write(&buf, order, uint16(2))
+		binary.Write(&buf, order, IFDFIA{ImageHeight, LONG, 1, 0x12345678})
+		binary.Write(&buf, order, IFDFIA{NewSubFileType, SLONG, 1, uint32(0xfffffffb)})
+		binary.Write(&buf, order, uint32(0))
+
+		//A single LONG is stored in the offset field, the count must not be mistaken for it.
+		meta, err := ExtractMetaDataWithOrder(bytes.NewReader(buf.Bytes()), order, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if long := *meta.FIAvals[0].long; len(long) != 1 || long[0] != 0x12345678 {
+			t.Errorf("%v: expected [0x12345678], got %#x", order, long)
+		}
+		if slong := *meta.FIAvals[1].slong; len(slong) != 1 || slong[0] != -5 {
+			t.Errorf("%v: expected [-5], got %v", order, slong)
+		}
+	}
+}
+
+func TestMalformedIFD(t *testing.T) {
+	order := binary.LittleEndian
+	valid := tinyTIFF(order, 6000, 4000)
+
+	if _, err := ParseHeader(bytes.NewReader(valid[:3])); err != ErrTruncated {
+		t.Error("Expected ErrTruncated for a short header, got:", err)
+	}
+
+	if _, err := ExtractMetaDataWithOrder(bytes.NewReader(valid), order, int64(len(valid)+100), 0); err != ErrValueOutOfRange {
+		t.Error("Expected ErrValueOutOfRange for an IFD past the end of the file, got:", err)
+	}
+
+	huge := append([]byte(nil), valid...)
+	order.PutUint16(huge[8:], 0xffff)
+	if _, err := ExtractMetaDataWithOrder(bytes.NewReader(huge), order, 8, 0); err != ErrBadIFD {
+		t.Error("Expected ErrBadIFD for an absurd entry count, got:", err)
+	}
+
+	long := append([]byte(nil), valid...)
+	order.PutUint16(long[8:], 100)
+	if _, err := ExtractMetaDataWithOrder(bytes.NewReader(long), order, 8, 0); err != ErrTruncated {
+		t.Error("Expected ErrTruncated for entries past the end of the file, got:", err)
+	}
+
+	//Turn ImageHeight in to a large array of LONGs pointing past the end of the file.
+	outside := append([]byte(nil), valid...)
+	order.PutUint32(outside[8+2+12+4:], 1<<20)
+	if _, err := ExtractMetaDataWithOrder(bytes.NewReader(outside), order, 8, 0); err != ErrValueOutOfRange {
+		t.Error("Expected ErrValueOutOfRange for a value past the end of the file, got:", err)
+	}
+
+	for i := range valid {
+		r := bytes.NewReader(valid[:i])
+		header, err := ParseHeader(r)
+		if err != nil {
+			continue
+		}
+		if _, err := ExtractMetaDataWithOrder(r, header.Order(), int64(header.Offset), 0); err == nil {
+			t.Errorf("Expected an error for a file truncated to %v bytes", i)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package arw
+
+//Options control how the raw data is developed, a nil *Options uses the defaults.
+type Options struct {
+	//Demosaicer interpolates the sensor mosaic, Bilinear is used when it is nil.
+	Demosaicer Demosaicer
+	//ColorSpace is the space the camera colours are converted to, the zero value is sRGB.
+	ColorSpace RGBSpace
+	//Transfer is the encoding of the output values, the zero value is the sRGB curve.
+	Transfer TransferFunction
+
+	//WhiteBalance picks one of the presets stored in the file, the zero value is the white balance the image was shot with.
+	WhiteBalance WhiteBalancePreset
+	//Temperature in Kelvin overrides WhiteBalance when it is set, the levels are interpolated from the presets in the file.
+	//Tint moves the result towards magenta when positive and towards green when negative, 100 is a factor of two in green.
+	Temperature, Tint float64
+	//AutoWhiteBalance estimates the white balance from the image when it is set, overriding the other white balance options.
+	AutoWhiteBalance WhiteBalanceEstimator
+
+	//Highlights is how highlights pushed past white by the white balance are handled, the zero value clips them.
+	Highlights HighlightMode
+	//LensCorrections picks which of the corrections the camera stored for the lens are applied, none are by default.
+	LensCorrections LensCorrection
+
+	//Uncropped returns the whole sensor readout instead of the crop the camera presents, which can have garbage along the edges.
+	Uncropped bool
+	//Upright turns and mirrors the image as the Orientation tag says, so portrait shots come out standing up.
+	Upright bool
+}
+
+//defaults returns o with every unset option replaced by its default.
+func (o *Options) defaults() Options {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.Demosaicer == nil {
+		opts.Demosaicer = Bilinear{}
+	}
+	return opts
+}
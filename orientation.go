@@ -0,0 +1,109 @@
+package arw
+
+import (
+	"image"
+	"image/draw"
+)
+
+//ImageOrientation is how the stored image has to be turned to be upright, as recorded in the Orientation tag.
+//The names give the visual position of the first row and the first column, CIPA DC-008-2012 Chapter 4.6.4.
+//go:generate stringer -type=ImageOrientation
+type ImageOrientation uint16
+
+const (
+	//OrientationTopLeft is upright as stored.
+	OrientationTopLeft ImageOrientation = iota + 1
+	//OrientationTopRight is mirrored left to right.
+	OrientationTopRight
+	//OrientationBottomRight is upside down.
+	OrientationBottomRight
+	//OrientationBottomLeft is mirrored top to bottom.
+	OrientationBottomLeft
+	//OrientationLeftTop is mirrored along the diagonal from the top left corner.
+	OrientationLeftTop
+	//OrientationRightTop needs to be turned a quarter clockwise, the camera was held with the grip up.
+	OrientationRightTop
+	//OrientationRightBottom is mirrored along the diagonal from the top right corner.
+	OrientationRightBottom
+	//OrientationLeftBottom needs to be turned a quarter counterclockwise, the camera was held with the grip down.
+	OrientationLeftBottom
+)
+
+//orientation returns the value of the Orientation tag, files without a valid one are taken to be upright.
+func orientation(tag uint16) ImageOrientation {
+	o := ImageOrientation(tag)
+	if o < OrientationTopLeft || o > OrientationLeftBottom {
+		return OrientationTopLeft
+	}
+	return o
+}
+
+//transposed reports whether rows become columns, which swaps the width and height.
+func (o ImageOrientation) transposed() bool {
+	return o >= OrientationLeftTop
+}
+
+//bounds returns the bounds of a w by h image once it is upright.
+func (o ImageOrientation) bounds(w, h int) image.Rectangle {
+	if o.transposed() {
+		w, h = h, w
+	}
+	return image.Rect(0, 0, w, h)
+}
+
+//position returns where the pixel at x, y of a w by h image ends up once the image is upright.
+func (o ImageOrientation) position(x, y, w, h int) (int, int) {
+	switch o {
+	case OrientationTopRight:
+		return w - 1 - x, y
+	case OrientationBottomRight:
+		return w - 1 - x, h - 1 - y
+	case OrientationBottomLeft:
+		return x, h - 1 - y
+	case OrientationLeftTop:
+		return y, x
+	case OrientationRightTop:
+		return h - 1 - y, x
+	case OrientationRightBottom:
+		return h - 1 - y, w - 1 - x
+	case OrientationLeftBottom:
+		return y, w - 1 - x
+	}
+	return x, y
+}
+
+//apply returns img turned upright, img itself when it already is.
+func (o ImageOrientation) apply(img *RGB14) *RGB14 {
+	if o == OrientationTopLeft {
+		return img
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	out := NewRGB14(o.bounds(w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			nx, ny := o.position(x, y, w, h)
+			out.set(nx, ny, img.at(x, y))
+		}
+	}
+	return out
+}
+
+//applyImage returns img turned upright as an RGBA image, img itself when it already is.
+func (o ImageOrientation) applyImage(img image.Image) image.Image {
+	if o == OrientationTopLeft {
+		return img
+	}
+	r := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(src, src.Rect, img, r.Min, draw.Src)
+
+	w, h := r.Dx(), r.Dy()
+	out := image.NewRGBA(o.bounds(w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			nx, ny := o.position(x, y, w, h)
+			copy(out.Pix[out.PixOffset(nx, ny):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return out
+}
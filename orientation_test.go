@@ -0,0 +1,121 @@
+package arw
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+func TestOrientations(t *testing.T) {
+	//Every pixel of a 3x2 image is told apart by its red value.
+	img := NewRGB14(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i].R = uint16('a' + i)
+	}
+
+	expected := map[ImageOrientation][]string{
+		OrientationTopLeft:     {"abc", "def"},
+		OrientationTopRight:    {"cba", "fed"},
+		OrientationBottomRight: {"fed", "cba"},
+		OrientationBottomLeft:  {"def", "abc"},
+		OrientationLeftTop:     {"ad", "be", "cf"},
+		OrientationRightTop:    {"da", "eb", "fc"},
+		OrientationRightBottom: {"fc", "eb", "da"},
+		OrientationLeftBottom:  {"cf", "be", "ad"},
+	}
+	for o, rows := range expected {
+		out := o.apply(img)
+		if out.Rect != image.Rect(0, 0, len(rows[0]), len(rows)) {
+			t.Errorf("%v: expected %vx%v, got %v", o, len(rows[0]), len(rows), out.Rect)
+			continue
+		}
+		for y, row := range rows {
+			for x := range row {
+				if got := out.at(x, y).R; got != uint16(row[x]) {
+					t.Errorf("%v: expected %c at %v,%v, got %c", o, row[x], x, y, got)
+				}
+			}
+		}
+	}
+
+	for _, tag := range []uint16{0, 9, 0xffff} {
+		if o := orientation(tag); o != OrientationTopLeft {
+			t.Errorf("Expected an invalid tag %v to be read as upright, got %v", tag, o)
+		}
+	}
+}
+
+func TestDecodeUpright(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	file.IFD0Tags = []arwtest.Tag{arwtest.Short(uint16(Orientation), uint16(OrientationRightTop))}
+
+	meta, err := ReadMetadata(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Orientation != OrientationRightTop {
+		t.Error("Expected the orientation to be reported, got:", meta.Orientation)
+	}
+
+	img, err := Decode(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upright, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{Upright: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upright.Bounds() != image.Rect(0, 0, file.Height, file.Width) {
+		t.Fatal("Expected a portrait image, got:", upright.Bounds())
+	}
+	//The top left corner of the stored image ends up in the top right corner.
+	if img.At(0, 0) != upright.At(file.Height-1, 0) {
+		t.Errorf("Expected %v in the top right corner, got %v", img.At(0, 0), upright.At(file.Height-1, 0))
+	}
+}
+
+func TestDecodePreviewUpright(t *testing.T) {
+	//A preview which is white on the left half and black on the right.
+	preview := image.NewGray(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			preview.SetGray(x, y, color.Gray{0xff})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, preview, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	file := syntheticFile(arwtest.Raw14)
+	file.IFD0Tags = []arwtest.Tag{arwtest.Short(uint16(Orientation), uint16(OrientationLeftBottom))}
+
+	if _, err := DecodePreview(bytes.NewReader(file.Bytes()), nil); err != ErrNoPreview {
+		t.Errorf("Expected %v, got %v", ErrNoPreview, err)
+	}
+
+	file.JPEG = encoded.Bytes()
+	img, err := DecodePreview(bytes.NewReader(file.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != preview.Bounds() {
+		t.Error("Expected the preview as stored, got:", img.Bounds())
+	}
+
+	img, err = DecodePreview(bytes.NewReader(file.Bytes()), &Options{Upright: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 8, 16) {
+		t.Fatal("Expected a portrait preview, got:", img.Bounds())
+	}
+	//Turned counterclockwise the white left half ends up at the bottom.
+	top, bottom := color.GrayModel.Convert(img.At(4, 2)).(color.Gray), color.GrayModel.Convert(img.At(4, 13)).(color.Gray)
+	if top.Y > 0x10 || bottom.Y < 0xf0 {
+		t.Errorf("Expected black above white, got %v and %v", top, bottom)
+	}
+}
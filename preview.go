@@ -0,0 +1,136 @@
+package arw
+
+import (
+	"image/jpeg"
+	"io"
+)
+
+//PreviewKind is what an embedded JPEG is for, told apart by where it is stored.
+//go:generate stringer -type=PreviewKind
+type PreviewKind uint8
+
+const (
+	//PreviewImage is the preview in IFD0 the camera shows during playback, 1616x1080 on recent bodies and smaller on older ones.
+	PreviewImage PreviewKind = iota
+	//PreviewThumbnail is the small thumbnail in IFD1, or any IFD following it.
+	PreviewThumbnail
+	//PreviewFullSize is a JPEG at the resolution of the sensor, stored in a SubIFD next to the raw data.
+	PreviewFullSize
+)
+
+//Preview describes a JPEG embedded in an ARW file.
+type Preview struct {
+	Kind   PreviewKind
+	Width  int
+	Height int
+	//Offset and Length locate the JPEG in the file, they can be passed to ExtractThumbnail.
+	Offset uint32
+	Length uint32
+}
+
+//jpegCompression holds the Compression values of the old and new style JPEG compression.
+var jpegCompression = map[uint32]bool{6: true, 7: true}
+
+//Previews returns every JPEG embedded in the ARW file r in the order they are found, walking the chain of IFDs
+//starting at IFD0 and the SubIFDs of each. Previews which are corrupt or don't fit in the file are left out,
+//a single broken one shouldn't hide the others.
+func Previews(r io.Reader) ([]Preview, error) {
+	rs, err := asReadSeekerAt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := ParseHeader(rs)
+	if err != nil {
+		return nil, err
+	}
+	order := header.Order()
+
+	var previews []Preview
+	add := func(ifd EXIFIFD, kind PreviewKind) {
+		if p, ok := jpegPreview(rs, ifd, kind); ok {
+			previews = append(previews, p)
+		}
+	}
+
+	//A corrupt file could link the IFDs in a loop.
+	visited := make(map[uint32]bool)
+	kind := PreviewImage
+	for offset := header.Offset; offset != 0 && !visited[offset]; {
+		visited[offset] = true
+		ifd, err := ExtractMetaDataWithOrder(rs, order, int64(offset), 0)
+		if err != nil {
+			//Without IFD0 this isn't a usable file, a broken IFD further along only ends the chain.
+			if kind == PreviewImage {
+				return nil, err
+			}
+			break
+		}
+		add(ifd, kind)
+
+		for n, fia := range ifd.FIA {
+			if fia.Tag != SubIFDs {
+				continue
+			}
+			for _, sub := range longs(ifd.FIAvals[n]) {
+				if subIFD, err := ExtractMetaDataWithOrder(rs, order, int64(sub), 0); err == nil {
+					add(subIFD, PreviewFullSize)
+				}
+			}
+		}
+		offset, kind = ifd.Offset, PreviewThumbnail
+	}
+	return previews, nil
+}
+
+//jpegPreview returns the JPEG stored in ifd, either through JPEGInterchangeFormat or as a JPEG compressed strip.
+//The raw data is never a preview, even when it is compressed as a lossless JPEG, nor is a JPEG which is
+//out of range or whose header can't be read.
+func jpegPreview(r readSeekerAt, ifd EXIFIFD, kind PreviewKind) (Preview, bool) {
+	p := Preview{Kind: kind}
+	var compression, stripOffset, stripLength uint32
+	for i, fia := range ifd.FIA {
+		switch fia.Tag {
+		case JPEGInterchangeFormat:
+			p.Offset = first(ifd.FIAvals[i])
+		case JPEGInterchangeFormatLength:
+			p.Length = first(ifd.FIAvals[i])
+		case Compression:
+			compression = first(ifd.FIAvals[i])
+		case StripOffsets:
+			stripOffset = first(ifd.FIAvals[i])
+		case StripByteCounts:
+			stripLength = first(ifd.FIAvals[i])
+		case SonyRawFileType:
+			return p, false
+		}
+	}
+	if p.Length == 0 && jpegCompression[compression] {
+		p.Offset, p.Length = stripOffset, stripLength
+	}
+	if p.Length == 0 {
+		return p, false
+	}
+
+	size, err := r.Seek(0, 2)
+	if err != nil || int64(p.Offset)+int64(p.Length) > size {
+		return p, false
+	}
+	conf, err := jpeg.DecodeConfig(io.NewSectionReader(r, int64(p.Offset), int64(p.Length)))
+	if err != nil {
+		return p, false
+	}
+	p.Width, p.Height = conf.Width, conf.Height
+	return p, true
+}
+
+//largest returns the preview with the most pixels.
+func largest(previews []Preview) Preview {
+	var l Preview
+	for _, p := range previews {
+		if p.Width*p.Height > l.Width*l.Height {
+			l = p
+		}
+	}
+	return l
+}
@@ -3,19 +3,19 @@ package arw
 import (
 	"bytes"
 	"fmt"
+	"image"
 	"image/jpeg"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/Soreil/arw/internal/arwtest"
 )
 
 func TestEmbeddedJPEGDecode(t *testing.T) {
-	testARW, err := os.Open("1.ARW")
-	if err != nil {
-		t.Error(err)
-	}
+	testARW := openSample(t, samples[CRAW][0])
 	header, err := ParseHeader(testARW)
-	meta, err := ExtractMetaData(testARW, int64(header.Offset), 0)
+	meta, err := ExtractMetaDataWithOrder(testARW, header.Order(), int64(header.Offset), 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -48,13 +48,10 @@ func TestEmbeddedJPEGDecode(t *testing.T) {
 }
 
 func TestEmbeddedJPEG(t *testing.T) {
-	testARW, err := os.Open("1.ARW")
-	if err != nil {
-		t.Error(err)
-	}
+	testARW := openSample(t, samples[CRAW][0])
 
 	header, err := ParseHeader(testARW)
-	meta, err := ExtractMetaData(testARW, int64(header.Offset), 0)
+	meta, err := ExtractMetaDataWithOrder(testARW, header.Order(), int64(header.Offset), 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -76,3 +73,134 @@ func TestEmbeddedJPEG(t *testing.T) {
 	out, err := os.Create(fmt.Sprint(time.Now().Unix(), "raw", ".jpg"))
 	out.Write(jpg)
 }
+
+func TestSyntheticPreview(t *testing.T) {
+	preview := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, preview, nil); err != nil {
+		t.Fatal(err)
+	}
+	file := syntheticFile(arwtest.Raw14)
+	file.JPEG = encoded.Bytes()
+	r := bytes.NewReader(file.Bytes())
+
+	header, err := ParseHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ExtractMetaDataWithOrder(r, header.Order(), int64(header.Offset), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jpegOffset, jpegLength uint32
+	for i := range meta.FIA {
+		switch meta.FIA[i].Tag {
+		case JPEGInterchangeFormat:
+			jpegOffset = meta.FIA[i].Offset
+		case JPEGInterchangeFormatLength:
+			jpegLength = meta.FIA[i].Offset
+		}
+	}
+	jpg, err := ExtractThumbnail(r, jpegOffset, jpegLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(jpg, encoded.Bytes()) {
+		t.Fatal("Extracted preview differs from the embedded JPEG")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(jpg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != preview.Bounds() {
+		t.Error("Expected", preview.Bounds(), "got:", img.Bounds())
+	}
+}
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+func TestPreviews(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	file.JPEG = encodeJPEG(t, 16, 8)
+	file.Thumbnail = encodeJPEG(t, 4, 2)
+	file.FullSizeJPEG = encodeJPEG(t, 64, 6)
+	r := bytes.NewReader(file.Bytes())
+
+	previews, err := Previews(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		kind          PreviewKind
+		width, height int
+		data          []byte
+	}{
+		{PreviewImage, 16, 8, file.JPEG},
+		{PreviewFullSize, 64, 6, file.FullSizeJPEG},
+		{PreviewThumbnail, 4, 2, file.Thumbnail},
+	}
+	if len(previews) != len(expected) {
+		t.Fatalf("Expected %v previews, got %+v", len(expected), previews)
+	}
+	for i, e := range expected {
+		p := previews[i]
+		if p.Kind != e.kind || p.Width != e.width || p.Height != e.height {
+			t.Errorf("Expected a %vx%v %v, got %+v", e.width, e.height, e.kind, p)
+		}
+		jpg, err := ExtractThumbnail(r, p.Offset, p.Length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(jpg, e.data) {
+			t.Errorf("%v: extracted JPEG differs from the embedded one", e.kind)
+		}
+	}
+
+	img, err := DecodePreview(bytes.NewReader(file.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 64, 6) {
+		t.Error("Expected the full size preview, got:", img.Bounds())
+	}
+
+	//The raw data is still found next to the full size JPEG.
+	if _, err := Decode(bytes.NewReader(file.Bytes())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPreviewsBroken(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	file.IFD0Tags = []arwtest.Tag{
+		arwtest.Long(uint16(JPEGInterchangeFormat), 1<<20),
+		arwtest.Long(uint16(JPEGInterchangeFormatLength), 1024),
+	}
+	file.FullSizeJPEG = []byte("not a JPEG")
+	file.Thumbnail = encodeJPEG(t, 4, 2)
+
+	//The preview past the end of the file and the one which doesn't decode are skipped, the thumbnail is still listed.
+	previews, err := Previews(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previews) != 1 || previews[0].Kind != PreviewThumbnail {
+		t.Fatalf("Expected only the thumbnail, got %+v", previews)
+	}
+
+	img, err := DecodePreview(bytes.NewReader(file.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Error("Expected the thumbnail, got:", img.Bounds())
+	}
+}
@@ -2,135 +2,241 @@ package arw
 
 import (
 	"image"
-	"reflect"
-	"unsafe"
+	"io"
+	"math"
 )
 
-func process(cur uint32, black uint32, whiteBalance float64) uint32 {
+//curves are the tone and output curves belonging to a single decode.
+type curves struct {
+	tone   *toneCurve
+	encode *transferLUT
+}
+
+//balance subtracts the black level and applies the white balance.
+//The result can go past the 14 bit white level, which leaves the highlight stage room to work with, and is clipped at 16 bits.
+func balance(cur uint32, black uint32, whiteBalance float64) uint16 {
 	if cur <= black {
 		return 0
-	} else {
-		cur -= black
 	}
+	balanced := float64(cur-black) * whiteBalance
+	if balanced > 0xffff {
+		return 0xffff
+	}
+	return uint16(balanced)
+}
 
-	balanced := float64(cur) * whiteBalance
-	balanced /= 0x3fff
-	return uint32(sRGB(gamma(balanced)))
+//output applies the tone and output curves to a linear 14 bit value.
+func (c *curves) output(v uint16) uint16 {
+	x := float64(v) / 0x3fff
+	if x > 1 {
+		x = 1
+	}
+	return c.encode.at(c.tone.gamma(x))
 }
 
-func readCRAW(buf []byte, rw rawDetails) *RGB14 {
-	img := NewRGB14(image.Rect(0, 0, int(rw.width), int(rw.height)))
+//unpackCRAW decompresses CRAW data and expands the 11 bit values to 14 bit linear values through the Sony curve.
+//Each row is made up of 32 pixel chunks stored as two blocks of 16 pixels, the first block holds the even columns.
+//Columns past the last full chunk are left black.
+func unpackCRAW(buf []byte, rw rawDetails) ([]uint16, error) {
+	width := int(rw.width)
+	if len(buf) < width*int(rw.height) {
+		return nil, ErrTruncated
+	}
+	data := make([]uint16, width*int(rw.height))
+	curve := sonyCurveLUT(rw.gammaCurve)
 
-	var gamma [6]float64
-	gamma[0] = 0
-	gamma[1] = float64(rw.gammaCurve[0])
-	gamma[2] = float64(rw.gammaCurve[1])
-	gamma[3] = float64(rw.gammaCurve[2])
-	gamma[4] = float64(rw.gammaCurve[3])
-	gamma[5] = float64(rw.gammaCurve[4])
-	createToneCurve(gamma)
+	for y := 0; y < int(rw.height); y++ {
+		for x := 0; x+32 <= width; x += 32 {
+			base := y*width + x
 
-	var whiteBalanceRGGB [4]float64
-	var maxBalance int16
-	if rw.WhiteBalance[0] > rw.WhiteBalance[1] {
-		maxBalance = rw.WhiteBalance[0]
-	} else {
-		maxBalance = rw.WhiteBalance[1]
+			even, err := readCrawBlock(buf[base:base+pixelBlockSize], rw.order).Decompress()
+			if err != nil {
+				return nil, err
+			}
+			odd, err := readCrawBlock(buf[base+pixelBlockSize:base+pixelBlockSize+pixelBlockSize], rw.order).Decompress()
+			if err != nil {
+				return nil, err
+			}
+
+			for i := 0; i < pixelBlockSize; i++ {
+				data[base+(i*2)] = curve[even[i]&0x7ff]
+				data[base+(i*2)+1] = curve[odd[i]&0x7ff]
+			}
+		}
 	}
-	if rw.WhiteBalance[2] > maxBalance {
-		maxBalance = rw.WhiteBalance[2]
+
+	return data, nil
+}
+
+//unpackRaw14 reads the uncompressed sensor values, every value is stored in two bytes.
+func unpackRaw14(buf []byte, rw rawDetails) ([]uint16, error) {
+	if len(buf) < int(rw.width)*int(rw.height)*2 {
+		return nil, ErrTruncated
 	}
-	if rw.WhiteBalance[3] > maxBalance {
-		maxBalance = rw.WhiteBalance[3]
+	data := make([]uint16, int(rw.width)*int(rw.height))
+	for i := range data {
+		data[i] = rw.order.Uint16(buf[i*2:])
 	}
+	return data, nil
+}
+
+//raw12RowLength returns the bytes taken by a row of 12 bit data, an odd width leaves its last pixel in a byte and a half.
+func raw12RowLength(width int) int {
+	return (width*3 + 1) / 2
+}
 
-	whiteBalanceRGGB[0] = float64(rw.WhiteBalance[0]) / float64(maxBalance)
-	whiteBalanceRGGB[1] = float64(rw.WhiteBalance[1]) / float64(maxBalance)
-	whiteBalanceRGGB[2] = float64(rw.WhiteBalance[2]) / float64(maxBalance)
-	whiteBalanceRGGB[3] = float64(rw.WhiteBalance[3]) / float64(maxBalance)
+//unpackRaw12 unpacks little endian 12 bit data where every 3 bytes hold 2 pixels.
+//Rows may be padded, the row length is derived from the size of the strip.
+func unpackRaw12(buf []byte, width, height int) []uint16 {
+	data := make([]uint16, width*height)
+	if height == 0 {
+		return data
+	}
 
-	for y := 0; y < img.Rect.Max.Y; y++ {
-		for x := 0; x < img.Rect.Max.X; x += 32 {
-			if y%2 == 0 {
-				base := y*img.Stride + x
+	rowLength := raw12RowLength(width)
+	if padded := len(buf) / height; padded > rowLength {
+		rowLength = padded
+	}
 
-				//fmt.Printf("Red block on line: %v\t column: %v\n", y, x)
-				block := readCrawBlock(buf[base : base+pixelBlockSize]) //16 red pixels, inverleaved with following 16 green
-				red := block.Decompress()
+	for y := 0; y < height; y++ {
+		row := data[y*width : (y+1)*width]
+		x, j := 0, y*rowLength
+		for ; x+1 < width && j+2 < len(buf); x, j = x+2, j+3 {
+			row[x] = uint16(buf[j]) | uint16(buf[j+1]&0x0f)<<8
+			row[x+1] = uint16(buf[j+1])>>4 | uint16(buf[j+2])<<4
+		}
+		if x < width && j+1 < len(buf) {
+			row[x] = uint16(buf[j]) | uint16(buf[j+1]&0x0f)<<8
+		}
+	}
+	return data
+}
 
-				//fmt.Printf("Green block on line: %v\t column: %v\n", y, x+pixelBlockSize)
-				block = readCrawBlock(buf[base+pixelBlockSize : base+pixelBlockSize+pixelBlockSize]) // idem
-				green := block.Decompress()
+//maxValue returns the largest value the raw format can hold, CRAW is expanded to 14 bits.
+//Lossless CRAW falls back to BitsPerSample here, decoding uses the precision recorded in the compressed data instead.
+func (rw rawDetails) maxValue() uint16 {
+	switch {
+	case rw.rawType == Raw12:
+		return 0xfff
+	case rw.rawType == CRAWLossless && rw.bitDepth > 0 && rw.bitDepth < 16:
+		return uint16(1)<<rw.bitDepth - 1
+	}
+	return 0x3fff
+}
 
-				for ir := range red {
-					red[ir] = pixel(process(uint32(red[ir]), uint32(rw.blackLevel[0]), whiteBalanceRGGB[0]))
-				}
+//readBayer unpacks the raw data of any supported format in to a mosaic of the values as recorded.
+func readBayer(r io.ReaderAt, rw rawDetails) (*Bayer, error) {
+	if rw.rawType == CRAWLossless {
+		data, precision, err := unpackLossless(r, rw)
+		if err != nil {
+			return nil, err
+		}
+		return newBayer(data, rw, uint16(1<<uint(precision)-1))
+	}
 
-				for ir := range green {
-					green[ir] = pixel(process(uint32(green[ir]), uint32(rw.blackLevel[1]), whiteBalanceRGGB[1]))
-				}
-				for i := 0; i < pixelBlockSize; i++ {
-					img.Pix[base+(i*2)].R = uint16(red[i])
-					img.Pix[base+(i*2)+1].G = uint16(green[i])
-				}
-			} else {
-				//fmt.Printf("Green block on line: %v\t column: %v\n", y, x)
-				base := y*img.Stride + x
+	buf := make([]byte, rw.length)
+	if _, err := r.ReadAt(buf, int64(rw.offset)); err != nil {
+		return nil, err
+	}
 
-				block := readCrawBlock(buf[base : base+pixelBlockSize]) //16 red pixels, inverleaved with following 16 green
-				green := block.Decompress()
+	switch rw.rawType {
+	case Raw14:
+		data, err := unpackRaw14(buf, rw)
+		if err != nil {
+			return nil, err
+		}
+		return newBayer(data, rw, rw.maxValue())
+	case Raw12:
+		if len(buf) < raw12RowLength(int(rw.width))*int(rw.height) {
+			return nil, ErrTruncated
+		}
+		return newBayer(unpackRaw12(buf, int(rw.width), int(rw.height)), rw, rw.maxValue())
+	case CRAW:
+		data, err := unpackCRAW(buf, rw)
+		if err != nil {
+			return nil, err
+		}
+		return newBayer(data, rw, rw.maxValue())
+	default:
+		return nil, ErrUnsupported
+	}
+}
 
-				//fmt.Printf("Green block on line: %v\t column: %v\n", y, x+pixelBlockSize)
-				block = readCrawBlock(buf[base+pixelBlockSize : base+pixelBlockSize+pixelBlockSize]) // idem
-				blue := block.Decompress()
+//unpackLossless assembles the tiles in to a single mosaic and returns it along with the sample precision.
+//When no tiles are present the strip is treated as a single tile covering the whole image.
+func unpackLossless(r io.ReaderAt, rw rawDetails) ([]uint16, int, error) {
+	width, height := int(rw.width), int(rw.height)
 
-				for ir := range green {
-					green[ir] = pixel(process(uint32(green[ir]), uint32(rw.blackLevel[2]), whiteBalanceRGGB[2]))
-				}
+	tileWidth, tileLength := int(rw.tileWidth), int(rw.tileLength)
+	offsets, counts := rw.tileOffsets, rw.tileCounts
+	if len(offsets) == 0 {
+		tileWidth, tileLength = width, height
+		offsets, counts = []uint32{rw.offset}, []uint32{rw.length}
+	}
+	if tileWidth == 0 || tileLength == 0 || len(offsets) != len(counts) {
+		return nil, 0, ErrUnsupported
+	}
+
+	//Every pixel takes at least a single bit of compressed data.
+	var total int64
+	for _, c := range counts {
+		total += int64(c)
+	}
+	if int64(width)*int64(height) > 8*total+64*int64(len(counts)) {
+		return nil, 0, ErrTruncated
+	}
+	data := make([]uint16, width*height)
+	tilesAcross := (width + tileWidth - 1) / tileWidth
+
+	precision := 0
+	for t := range offsets {
+		buf := make([]byte, counts[t])
+		if _, err := r.ReadAt(buf, int64(offsets[t])); err != nil {
+			return nil, 0, err
+		}
+		tile, err := decodeLJPEG(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		precision = tile.precision
+
+		top, left := (t/tilesAcross)*tileLength, (t%tilesAcross)*tileWidth
+		set := func(y, x int, v uint16) {
+			if y < height && x < width {
+				data[y*width+x] = v
+			}
+		}
 
-				for ir := range blue {
-					blue[ir] = pixel(process(uint32(blue[ir]), uint32(rw.blackLevel[3]), whiteBalanceRGGB[3]))
+		switch {
+		case tile.components == 4 && tile.width*2 == tileWidth && tile.height*2 == tileLength:
+			//Sony stores every 2x2 CFA block as a single sample with four components.
+			for y := 0; y < tile.height; y++ {
+				for x := 0; x < tile.width; x++ {
+					px := tile.pix[(y*tile.width+x)*4:]
+					set(top+2*y, left+2*x, px[0])
+					set(top+2*y, left+2*x+1, px[1])
+					set(top+2*y+1, left+2*x, px[2])
+					set(top+2*y+1, left+2*x+1, px[3])
 				}
-				for i := 0; i < pixelBlockSize; i++ {
-					img.Pix[base+(i*2)].G = uint16(green[i])
-					img.Pix[base+(i*2)+1].B = uint16(blue[i])
+			}
+		case tile.width*tile.components == tileWidth && tile.height == tileLength:
+			for y := 0; y < tileLength; y++ {
+				for x := 0; x < tileWidth; x++ {
+					set(top+y, left+x, tile.pix[y*tileWidth+x])
 				}
 			}
-		}
-	}
-	for y := 0; y < img.Rect.Max.Y; y++ {
-		for x := 0; x < img.Rect.Max.X; x++ {
-			img.Pix[y*img.Stride+x].G = img.Pix[y*img.Stride+x+1].G
-			img.Pix[y*img.Stride+x].B = img.Pix[(y+1)*img.Stride+x+1].B
-			x++
-			img.Pix[y*img.Stride+x].R = img.Pix[y*img.Stride+x-1].R
-			img.Pix[y*img.Stride+x].B = img.Pix[(y+1)*img.Stride+x].B
-		}
-		y++
-
-		for x := 0; x < img.Rect.Max.X; x++ {
-			img.Pix[y*img.Stride+x].R = img.Pix[(y-1)*img.Stride+x].R
-			img.Pix[y*img.Stride+x].B = img.Pix[y*img.Stride+x+1].B
-			x++
-			img.Pix[y*img.Stride+x].R = img.Pix[(y-1)*img.Stride+x-1].R
-			img.Pix[y*img.Stride+x].G = img.Pix[y*img.Stride+x-1].G
+		default:
+			return nil, 0, ErrUnsupported
 		}
 	}
 
-	return img
+	return data, precision, nil
 }
 
-func readRaw14(buf []byte, rw rawDetails) *RGB14 {
-	//Since we are working with 14 it bytes we choose to simply change the slice's header
-	sliceHeader := *(*reflect.SliceHeader)(unsafe.Pointer(&buf))
-	sliceHeader.Len /= 2
-	sliceHeader.Cap /= 2
-	data := *(*[]uint16)(unsafe.Pointer(&sliceHeader))
-
-	img := NewRGB14(image.Rect(0, 0, int(rw.width), int(rw.height)))
-
-	var cur uint32
-
+//develop scales the mosaic to 14 bits so every format goes through the same pipeline, balances it,
+//demosaics it, corrects the lens, handles the highlights, converts it to the output colour space, applies the tone and output curves
+//and crops it.
+func develop(b *Bayer, rw rawDetails, opts Options) *RGB14 {
 	var gamma [6]float64
 	gamma[0] = 0
 	gamma[1] = float64(rw.gammaCurve[0])
@@ -145,71 +251,93 @@ func readRaw14(buf []byte, rw rawDetails) *RGB14 {
 	gamma[4] /= gamma[5]
 	gamma[5] /= gamma[5]
 
-	createToneCurve(gamma)
-	createSRGBCurve()
+	c := &curves{
+		tone:   createToneCurve(gamma),
+		encode: newTransferLUT(opts.Transfer),
+	}
 
-	var whiteBalanceRGGB [4]float64
-	var maxBalance int16
-	if rw.WhiteBalance[0] > rw.WhiteBalance[1] {
-		maxBalance = rw.WhiteBalance[0]
+	//The levels are normalised to the smallest so every channel reaches white by the time it saturates on the sensor,
+	//what happens past that is up to the highlight mode.
+	whiteBalanceRGGB := [4]float64{1, 1, 1, 1}
+	var minBalance int16
+	if rw.WhiteBalance[0] < rw.WhiteBalance[1] {
+		minBalance = rw.WhiteBalance[0]
 	} else {
-		maxBalance = rw.WhiteBalance[1]
+		minBalance = rw.WhiteBalance[1]
 	}
-	if rw.WhiteBalance[2] > maxBalance {
-		maxBalance = rw.WhiteBalance[2]
+	if rw.WhiteBalance[2] < minBalance {
+		minBalance = rw.WhiteBalance[2]
 	}
-	if rw.WhiteBalance[3] > maxBalance {
-		maxBalance = rw.WhiteBalance[3]
+	if rw.WhiteBalance[3] < minBalance {
+		minBalance = rw.WhiteBalance[3]
 	}
 
-	whiteBalanceRGGB[0] = float64(rw.WhiteBalance[0]) / float64(maxBalance)
-	whiteBalanceRGGB[1] = float64(rw.WhiteBalance[1]) / float64(maxBalance)
-	whiteBalanceRGGB[2] = float64(rw.WhiteBalance[2]) / float64(maxBalance)
-	whiteBalanceRGGB[3] = float64(rw.WhiteBalance[3]) / float64(maxBalance)
-
-	for y := 0; y < img.Rect.Max.Y; y++ {
-		for x := 0; x < img.Rect.Max.X; x++ {
-			cur = uint32(data[y*img.Stride+x])
-			cur = process(cur, uint32(rw.blackLevel[0]), whiteBalanceRGGB[0])
-			img.Pix[y*img.Stride+x].R = uint16(cur)
-			x++
-
-			cur = uint32(data[y*img.Stride+x])
-			cur = process(cur, uint32(rw.blackLevel[1]), whiteBalanceRGGB[1])
-			img.Pix[y*img.Stride+x].G = uint16(cur)
-		}
-		y++
+	if minBalance > 0 {
+		whiteBalanceRGGB[0] = float64(rw.WhiteBalance[0]) / float64(minBalance)
+		whiteBalanceRGGB[1] = float64(rw.WhiteBalance[1]) / float64(minBalance)
+		whiteBalanceRGGB[2] = float64(rw.WhiteBalance[2]) / float64(minBalance)
+		whiteBalanceRGGB[3] = float64(rw.WhiteBalance[3]) / float64(minBalance)
+	}
 
-		for x := 0; x < img.Rect.Max.X; x++ {
-			cur = uint32(data[y*img.Stride+x])
-			cur = process(cur, uint32(rw.blackLevel[2]), whiteBalanceRGGB[2])
-			img.Pix[y*img.Stride+x].G = uint16(cur)
-			x++
+	var whiteBalance [4]float64
+	for i, n := range rggbIndex(b.Pattern) {
+		whiteBalance[i] = whiteBalanceRGGB[n]
+	}
 
-			cur = uint32(data[y*img.Stride+x])
-			cur = process(cur, uint32(rw.blackLevel[3]), whiteBalanceRGGB[3])
-			img.Pix[y*img.Stride+x].B = uint16(cur)
+	//Every channel is stretched so its white level ends up at 14 bit white whatever the bit depth of the file.
+	var gain [4]float64
+	for n := range gain {
+		if span := int(b.WhiteLevel[n]) - int(b.BlackLevel[n]); span > 0 {
+			gain[n] = whiteBalance[n] * 0x3fff / float64(span)
 		}
 	}
 
-	for y := 0; y < img.Rect.Max.Y; y++ {
-		for x := 0; x < img.Rect.Max.X; x++ {
-			img.Pix[y*img.Stride+x].G = img.Pix[y*img.Stride+x+1].G
-			img.Pix[y*img.Stride+x].B = img.Pix[(y+1)*img.Stride+x+1].B
-			x++
-			img.Pix[y*img.Stride+x].R = img.Pix[y*img.Stride+x-1].R
-			img.Pix[y*img.Stride+x].B = img.Pix[(y+1)*img.Stride+x].B
-		}
-		y++
-
-		for x := 0; x < img.Rect.Max.X; x++ {
-			img.Pix[y*img.Stride+x].R = img.Pix[(y-1)*img.Stride+x].R
-			img.Pix[y*img.Stride+x].B = img.Pix[y*img.Stride+x+1].B
-			x++
-			img.Pix[y*img.Stride+x].R = img.Pix[(y-1)*img.Stride+x-1].R
-			img.Pix[y*img.Stride+x].G = img.Pix[y*img.Stride+x-1].G
+	linear := *b
+	linear.Pix = make([]uint16, len(b.Pix))
+	linear.BlackLevel = [4]uint16{}
+	for n, wb := range whiteBalance {
+		linear.WhiteLevel[n] = uint16(math.Min(0xffff, 0x3fff*wb))
+	}
+	for y := b.Rect.Min.Y; y < b.Rect.Max.Y; y++ {
+		for x := b.Rect.Min.X; x < b.Rect.Max.X; x++ {
+			i := b.PixOffset(x, y)
+			n := b.patternIndex(x, y)
+			linear.Pix[i] = balance(uint32(b.Pix[i]), uint32(b.BlackLevel[n]), gain[n])
 		}
 	}
 
+	img := opts.Demosaicer.Demosaic(&linear)
+	//Clipping is judged against the white level of the sensor, brightening the corners first would push
+	//photosites which never saturated past it. The gain is applied in sensor coordinates, before the remap.
+	opts.Highlights.apply(img, &linear)
+	lens := newLensProfile(rw)
+	if opts.LensCorrections&CorrectVignetting != 0 {
+		lens.correctVignetting(img, b)
+	}
+	img = lens.remap(img, b.Crop, opts.LensCorrections)
+	colorTransform(rw, opts.ColorSpace).convert(img)
+	for i := range img.Pix {
+		px := &img.Pix[i]
+		px.R, px.G, px.B = c.output(px.R), c.output(px.G), c.output(px.B)
+	}
+	if !opts.Uncropped {
+		img = cropImage(img, b.Crop)
+	}
+	if opts.Upright {
+		img = rw.orientation.apply(img)
+	}
 	return img
 }
+
+//cropImage copies the part of img within r to a new image whose bounds start at the origin.
+func cropImage(img *RGB14, r image.Rectangle) *RGB14 {
+	r = r.Intersect(img.Rect)
+	if r == img.Rect && r.Min == (image.Point{}) {
+		return img
+	}
+	out := NewRGB14(image.Rect(0, 0, r.Dx(), r.Dy()))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(out.Pix[out.offset(0, y-r.Min.Y):out.offset(r.Dx(), y-r.Min.Y)], img.Pix[img.offset(r.Min.X, y):img.offset(r.Max.X, y)])
+	}
+	return out
+}
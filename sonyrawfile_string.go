@@ -1,16 +0,0 @@
-// Code generated by "stringer -type=sonyRawFile"; DO NOT EDIT.
-
-package arw
-
-import "fmt"
-
-const _sonyRawFile_name = "raw14raw12crawcrawLossless"
-
-var _sonyRawFile_index = [...]uint8{0, 5, 10, 14, 26}
-
-func (i sonyRawFile) String() string {
-	if i >= sonyRawFile(len(_sonyRawFile_index)-1) {
-		return fmt.Sprintf("sonyRawFile(%d)", i)
-	}
-	return _sonyRawFile_name[_sonyRawFile_index[i]:_sonyRawFile_index[i+1]]
-}
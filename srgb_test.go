@@ -1,33 +1,146 @@
 package arw
 
 import (
-	"fmt"
+	"math"
+	"reflect"
+	"sync"
 	"testing"
 )
 
-func TestSRGB(t *testing.T) {
-	createSRGBCurve()
-	var results []float64
-	for i := 0.00; i <= 1.00; i += 0.01 {
-		//	t.Logf("%.2f:\t%.2f\t%x", i, sRGB(i), int(sRGB(i)))
-		results = append(results, sRGB(i))
+func TestTransferFunctions(t *testing.T) {
+	expected := []struct {
+		f    TransferFunction
+		x, y float64
+	}{
+		{TransferSRGB, 0.001, 0.01292},
+		{TransferSRGB, 0.0031308, 0.04045},
+		{TransferSRGB, 0.18, 0.46135},
+		{TransferSRGB, 0.5, 0.73536},
+		{TransferRec709, 0.01, 0.045},
+		{TransferRec709, 0.018, 0.08124},
+		{TransferRec709, 0.5, 0.70552},
+		{TransferGamma22, 0.5, 0.72974},
+		{TransferLinear, 0.5, 0.5},
 	}
-	fmt.Println("# x y")
-	for i, v := range results {
-		fmt.Printf("%.2v %#x\n", float64(i)*0.01, int(v))
+	for _, e := range expected {
+		if y := e.f.encode(e.x); math.Abs(y-e.y) > 1e-4 {
+			t.Errorf("%v(%v): expected %v, got %v", e.f, e.x, e.y, y)
+		}
+	}
+
+	for _, f := range []TransferFunction{TransferSRGB, TransferRec709, TransferGamma22, TransferLinear} {
+		lut := newTransferLUT(f)
+		if lut[0] != 0 || lut[len(lut)-1] != 0x3fff {
+			t.Errorf("%v: expected the LUT to span 0 to %#x, got %v to %#x", f, 0x3fff, lut[0], lut[len(lut)-1])
+		}
+		for i := 1; i < len(lut); i++ {
+			if lut[i] < lut[i-1] {
+				t.Fatalf("%v: LUT isn't monotonic at %v", f, i)
+			}
+		}
+		if lut.at(-0.5) != lut[0] || lut.at(1.5) != lut[len(lut)-1] {
+			t.Errorf("%v: expected values outside of 0 to 1 to be clipped", f)
+		}
 	}
 }
 
 func TestToneCurve(t *testing.T) {
-	createToneCurve([6]float64{0, 8000, 10400, 12900, 14100, 0x3fff})
-	var results []float64
-	for i := 0.00; i <= 1.00; i += 0.01 {
-		//t.Logf("%d:\t%.2f\t%x", i, gamma(float64(i)), int(gamma(float64(i))))
-		results = append(results, gamma(float64(i)))
+	points := [6]float64{0, 8000, 10400, 12900, 14100, 0x3fff}
+	for i := range points {
+		points[i] /= 0x3fff
+	}
+	curve := createToneCurve(points)
+
+	for i, p := range points {
+		if y := curve.gamma(float64(i) * 0.2); math.Abs(y-p) > 1./0x3fff {
+			t.Errorf("Expected the curve to pass through %v at %v, got %v", p, float64(i)*0.2, y)
+		}
+	}
+	for i := 1; i < len(curve); i++ {
+		if curve[i] < curve[i-1] {
+			t.Fatalf("Curve isn't monotonic at %v: %v < %v", i, curve[i], curve[i-1])
+		}
+	}
+	if curve.gamma(-1) != 0 || curve.gamma(1.5) != 1 {
+		t.Error("Expected values outside of 0 to 1 to be clipped, got:", curve.gamma(-1), curve.gamma(1.5))
+	}
+
+	//A flat stretch between two points has to stay flat instead of overshooting.
+	flat := createToneCurve([6]float64{0, 0.5, 0.5, 0.5, 0.9, 1})
+	for x := 0.2; x <= 0.6; x += 0.01 {
+		if y := flat.gamma(x); math.Abs(y-0.5) > 1./0x3fff {
+			t.Fatalf("Expected the flat stretch to stay at 0.5, got %v at %v", y, x)
+		}
+	}
+
+	var none [6]float64
+	linear := createToneCurve(none)
+	if linear.gamma(0.25) != float64(linear[0x1000])/0x3fff || linear[0x1000] != 0x1000 {
+		t.Error("Expected a missing curve to leave the data linear")
+	}
+}
+
+func TestSonyCurveLUT(t *testing.T) {
+	lut := sonyCurveLUT([5]uint16{8000, 10400, 12900, 14100, 0x3fff})
+
+	if lut[0] != 0 {
+		t.Error("Expected black to stay black, got:", lut[0])
+	}
+	//Below the first point the curve is linear, every 11 bit step is two 14 bit steps.
+	if lut[100] != 200 {
+		t.Error("Expected linear start of the curve, got:", lut[100])
+	}
+	for i := 1; i < len(lut); i++ {
+		if lut[i] < lut[i-1] {
+			t.Fatalf("Curve isn't monotonic at %v: %v < %v", i, lut[i], lut[i-1])
+		}
+	}
+	if lut[len(lut)-1] != 0x3fff {
+		t.Errorf("Expected the curve to reach 14 bit white, got: %#x", lut[len(lut)-1])
+	}
+}
+
+func TestConcurrentCurves(t *testing.T) {
+	var a, b rawDetails
+	a.width, a.height = 8, 8
+	a.gammaCurve = [5]uint16{8000, 10400, 12900, 14100, 0x3fff}
+	a.WhiteBalance = [4]int16{2400, 1024, 1024, 1800}
+	a.cfaPattern, a.cfaPatternDim = []uint8{0, 1, 1, 2}, [2]uint16{2, 2}
+	b = a
+	b.gammaCurve = [5]uint16{4000, 8000, 10000, 12000, 0x3fff}
+
+	data := make([]uint16, 8*8)
+	for i := range data {
+		data[i] = uint16(i * 200)
+	}
+
+	//develop only reads the mosaic, so every goroutine shares it.
+	mosaic, err := newBayer(data, a, 0x3fff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedA := develop(mosaic, a, (&Options{}).defaults())
+	expectedB := develop(mosaic, b, (&Options{}).defaults())
+	if reflect.DeepEqual(expectedA.Pix, expectedB.Pix) {
+		t.Fatal("Expected different curves to give different results")
 	}
 
-	fmt.Println("# x y")
-	for i, v := range results {
-		fmt.Println(0.01*float64(i), v)
+	var wg sync.WaitGroup
+	for _, c := range []struct {
+		rw       rawDetails
+		expected *RGB14
+	}{{a, expectedA}, {b, expectedB}} {
+		wg.Add(1)
+		go func(rw rawDetails, expected *RGB14) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				img := develop(mosaic, rw, (&Options{}).defaults())
+				if !reflect.DeepEqual(img.Pix, expected.Pix) {
+					t.Error("Concurrent decode differs from serial decode for curve", rw.gammaCurve)
+					return
+				}
+			}
+		}(c.rw, c.expected)
 	}
+	wg.Wait()
 }
@@ -0,0 +1,409 @@
+package arw
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"sort"
+)
+
+//TIFFCompression is the compression EncodeTIFF applies to the image data.
+//go:generate stringer -type=TIFFCompression
+type TIFFCompression uint8
+
+const (
+	//TIFFUncompressed stores the samples as they are, the largest files but the quickest to write and read.
+	TIFFUncompressed TIFFCompression = iota
+	//TIFFDeflate is the zlib compression Adobe added to TIFF, it compresses best.
+	TIFFDeflate
+	//TIFFLZW is the LZW compression from the TIFF 6.0 specification, which every reader supports.
+	TIFFLZW
+)
+
+//tiffCompressionCodes holds the values of the Compression tag.
+var tiffCompressionCodes = [...]uint16{TIFFUncompressed: 1, TIFFDeflate: 8, TIFFLZW: 5}
+
+//ErrCompression is returned when EncodeTIFF is asked for a compression it doesn't know.
+var ErrCompression = errors.New("unknown TIFF compression")
+
+//ErrEmptyImage is returned when EncodeTIFF is given an image without any pixels.
+var ErrEmptyImage = errors.New("can't encode an empty image")
+
+//TIFFOptions control how EncodeTIFF writes the image, a nil *TIFFOptions writes uncompressed 16 bit samples.
+type TIFFOptions struct {
+	//EightBit writes 8 bits per sample instead of 16.
+	EightBit    bool
+	Compression TIFFCompression
+	//ICCProfile is embedded when it is set, it should describe the RGBSpace and TransferFunction the image was developed to.
+	ICCProfile []byte
+}
+
+//tiffStripSize is roughly the size of an uncompressed strip, the size TIFF 6.0 recommends.
+const tiffStripSize = 8 << 10
+
+//EncodeTIFF writes img to w as an RGB TIFF. When raw isn't nil it is read as the ARW file img was developed from,
+//its Exif and GPS IFDs are copied along with the camera make, model and date.
+//The MakerNote is left out as Sony stores offsets in it which would no longer point anywhere, and so is the Orientation:
+//develop with Options.Upright to keep portrait shots standing up.
+func EncodeTIFF(w io.Writer, img image.Image, raw io.Reader, opts *TIFFOptions) error {
+	var o TIFFOptions
+	if opts != nil {
+		o = *opts
+	}
+	if int(o.Compression) >= len(tiffCompressionCodes) {
+		return ErrCompression
+	}
+
+	var ifd0, exif, gps tiffIFD
+	if raw != nil {
+		var err error
+		if ifd0, exif, gps, err = copyExif(raw); err != nil {
+			return err
+		}
+	}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return ErrEmptyImage
+	}
+	bits := 16
+	if o.EightBit {
+		bits = 8
+	}
+	rowSize := bounds.Dx() * 3 * bits / 8
+	rowsPerStrip := tiffStripSize / rowSize
+	if rowsPerStrip < 1 {
+		rowsPerStrip = 1
+	}
+
+	//The size of a compressed strip is only known once it has been compressed, so those are held until the IFD is
+	//written. Uncompressed strips are made as they are written.
+	numStrips := (bounds.Dy() + rowsPerStrip - 1) / rowsPerStrip
+	stripOffsets := make([]uint32, numStrips)
+	stripCounts := make([]uint32, numStrips)
+	var strips [][]byte
+	for i := range stripCounts {
+		y := bounds.Min.Y + i*rowsPerStrip
+		if o.Compression == TIFFUncompressed {
+			rows := bounds.Max.Y - y
+			if rows > rowsPerStrip {
+				rows = rowsPerStrip
+			}
+			stripCounts[i] = uint32(rows * rowSize)
+			continue
+		}
+		data, err := compressStrip(tiffStrip(img, y, rowsPerStrip, o.EightBit), o.Compression)
+		if err != nil {
+			return err
+		}
+		strips = append(strips, data)
+		stripCounts[i] = uint32(len(data))
+	}
+	ifd0 = append(ifd0,
+		longField(ImageWidth, uint32(bounds.Dx())),
+		longField(ImageHeight, uint32(bounds.Dy())),
+		shortField(BitsPerSample, uint16(bits), uint16(bits), uint16(bits)),
+		shortField(Compression, tiffCompressionCodes[o.Compression]),
+		shortField(PhotometricInterpretation, 2),
+		longField(StripOffsets, stripOffsets...),
+		shortField(SamplesPerPixel, 3),
+		longField(RowsPerStrip, uint32(rowsPerStrip)),
+		longField(StripByteCounts, stripCounts...),
+		shortField(PlanarConfiguration, 1),
+	)
+	if o.ICCProfile != nil {
+		ifd0 = append(ifd0, tiffField{InterColorProfile, UNDEFINED, uint32(len(o.ICCProfile)), o.ICCProfile})
+	}
+	if exif != nil {
+		ifd0 = append(ifd0, longField(ExifTag, 0))
+	}
+	if gps != nil {
+		ifd0 = append(ifd0, longField(GPSTag, 0))
+	}
+	ifd0.sort()
+	exif.sort()
+	gps.sort()
+
+	//The IFDs come first, followed by the strips.
+	const headerSize = 8
+	exifOffset := headerSize + ifd0.size()
+	gpsOffset := exifOffset + exif.size()
+	offset := gpsOffset + gps.size()
+	for i, count := range stripCounts {
+		stripOffsets[i] = uint32(offset)
+		offset += int(count)
+	}
+	ifd0.set(StripOffsets, stripOffsets...)
+	ifd0.set(ExifTag, uint32(exifOffset))
+	ifd0.set(GPSTag, uint32(gpsOffset))
+
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(headerSize))
+	ifd0.write(&buf)
+	exif.write(&buf)
+	gps.write(&buf)
+	if _, err := buf.WriteTo(w); err != nil {
+		return err
+	}
+
+	for i := range stripCounts {
+		var strip []byte
+		if strips != nil {
+			strip = strips[i]
+		} else {
+			strip = tiffStrip(img, bounds.Min.Y+i*rowsPerStrip, rowsPerStrip, o.EightBit)
+		}
+		if _, err := w.Write(strip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//tiffStrip returns the samples of up to rows rows of img starting at row y.
+func tiffStrip(img image.Image, y, rows int, eightBit bool) []byte {
+	bounds := img.Bounds()
+	if y+rows > bounds.Max.Y {
+		rows = bounds.Max.Y - y
+	}
+	rowSize := bounds.Dx() * 3 * 2
+	if eightBit {
+		rowSize = bounds.Dx() * 3
+	}
+	strip := make([]byte, rows*rowSize)
+	for i := 0; i < rows; i++ {
+		tiffRow(strip[i*rowSize:(i+1)*rowSize], img, y+i, eightBit)
+	}
+	return strip
+}
+
+//tiffRow fills row with the samples of row y of img, 16 bit samples are little endian.
+func tiffRow(row []byte, img image.Image, y int, eightBit bool) {
+	bounds := img.Bounds()
+	rgb14, _ := img.(*RGB14)
+	i := 0
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		var r, g, b uint32
+		if rgb14 != nil {
+			r, g, b, _ = rgb14.Pix[rgb14.offset(x, y)].RGBA()
+		} else {
+			r, g, b, _ = img.At(x, y).RGBA()
+		}
+		for _, v := range [3]uint32{r, g, b} {
+			if eightBit {
+				row[i] = uint8(v >> 8)
+				i++
+			} else {
+				binary.LittleEndian.PutUint16(row[i:], uint16(v))
+				i += 2
+			}
+		}
+	}
+}
+
+func compressStrip(data []byte, c TIFFCompression) ([]byte, error) {
+	switch c {
+	case TIFFDeflate:
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case TIFFLZW:
+		return lzwEncode(data), nil
+	}
+	return data, nil
+}
+
+//exifIFD0Tags are the fields of IFD0 which are copied along with the Exif IFD.
+var exifIFD0Tags = map[IFDtag]bool{Make: true, Model: true, DateTime: true}
+
+//copyExif reads the fields of the ARW file raw which EncodeTIFF copies, all in little endian.
+func copyExif(raw io.Reader) (ifd0, exif, gps tiffIFD, err error) {
+	rs, err := asReadSeekerAt(raw)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := rs.Seek(0, 0); err != nil {
+		return nil, nil, nil, err
+	}
+	header, err := ParseHeader(rs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	order := header.Order()
+
+	meta, err := ExtractMetaDataWithOrder(rs, order, int64(header.Offset), 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ifd0, err = copyFields(rs, order, meta, func(tag IFDtag) bool { return exifIFD0Tags[tag] })
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, fia := range meta.FIA {
+		var copied *tiffIFD
+		var keep func(IFDtag) bool
+		switch fia.Tag {
+		case ExifTag:
+			//Only Exif fields which don't point elsewhere in the file are kept.
+			copied, keep = &exif, func(tag IFDtag) bool { return tag != MakerNote && tag != InteroperabilityTag }
+		case GPSTag:
+			copied, keep = &gps, func(IFDtag) bool { return true }
+		default:
+			continue
+		}
+		sub, err := ExtractMetaDataWithOrder(rs, order, int64(fia.Offset), 0)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if *copied, err = copyFields(rs, order, sub, keep); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return ifd0, exif, gps, nil
+}
+
+//copyFields returns the fields of ifd keep selects with their values read from r and converted to little endian.
+//Fields of an unknown type are left out.
+func copyFields(r io.ReaderAt, order binary.ByteOrder, ifd EXIFIFD, keep func(IFDtag) bool) (tiffIFD, error) {
+	var fields tiffIFD
+	for _, fia := range ifd.FIA {
+		size := fia.Type.Len()
+		if size < 0 || !keep(fia.Tag) {
+			continue
+		}
+		length := int64(size) * int64(fia.Count)
+		if length > maxValueSize {
+			return nil, ErrValueOutOfRange
+		}
+
+		value := make([]byte, length)
+		if length <= 4 {
+			var inline [4]byte
+			order.PutUint32(inline[:], fia.Offset)
+			copy(value, inline[:])
+		} else if _, err := r.ReadAt(value, int64(fia.Offset)); err != nil {
+			return nil, ErrTruncated
+		}
+
+		if order != binary.LittleEndian {
+			//Rationals are two LONGs, everything else is swapped by the size of its type.
+			if fia.Type == RATIONAL || fia.Type == SRATIONAL {
+				size = 4
+			}
+			swapBytes(value, size)
+		}
+		fields = append(fields, tiffField{fia.Tag, fia.Type, fia.Count, value})
+	}
+	return fields, nil
+}
+
+//swapBytes reverses the byte order of every size byte value in b.
+func swapBytes(b []byte, size int) {
+	for i := 0; i+size <= len(b); i += size {
+		for l, r := i, i+size-1; l < r; l, r = l+1, r-1 {
+			b[l], b[r] = b[r], b[l]
+		}
+	}
+}
+
+//tiffField is a single IFD entry with its value in little endian.
+type tiffField struct {
+	tag   IFDtag
+	typ   IFDtype
+	count uint32
+	value []byte
+}
+
+func shortField(tag IFDtag, values ...uint16) tiffField {
+	value := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(value[2*i:], v)
+	}
+	return tiffField{tag, SHORT, uint32(len(values)), value}
+}
+
+func longField(tag IFDtag, values ...uint32) tiffField {
+	value := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(value[4*i:], v)
+	}
+	return tiffField{tag, LONG, uint32(len(values)), value}
+}
+
+//tiffIFD is an IFD to be written, a nil tiffIFD isn't written at all.
+type tiffIFD []tiffField
+
+//sort puts the fields in ascending order of their tag, as TIFF requires.
+func (ifd tiffIFD) sort() {
+	sort.SliceStable(ifd, func(i, j int) bool { return ifd[i].tag < ifd[j].tag })
+}
+
+//set changes the values of a LONG field.
+func (ifd tiffIFD) set(tag IFDtag, values ...uint32) {
+	for _, f := range ifd {
+		if f.tag == tag {
+			for i, v := range values {
+				binary.LittleEndian.PutUint32(f.value[4*i:], v)
+			}
+		}
+	}
+}
+
+//size is the size of the IFD including the values stored after it, every value is word aligned.
+func (ifd tiffIFD) size() int {
+	if ifd == nil {
+		return 0
+	}
+	size := 2 + 12*len(ifd) + 4
+	for _, f := range ifd {
+		if len(f.value) > 4 {
+			size += len(f.value) + len(f.value)&1
+		}
+	}
+	return size
+}
+
+//write writes the IFD at the current position of buf followed by its values, there is no next IFD.
+func (ifd tiffIFD) write(buf *bytes.Buffer) {
+	if ifd == nil {
+		return
+	}
+	le := binary.LittleEndian
+	values := buf.Len() + 2 + 12*len(ifd) + 4
+
+	binary.Write(buf, le, uint16(len(ifd)))
+	for _, f := range ifd {
+		binary.Write(buf, le, f.tag)
+		binary.Write(buf, le, f.typ)
+		binary.Write(buf, le, f.count)
+		if len(f.value) <= 4 {
+			var inline [4]byte
+			copy(inline[:], f.value)
+			buf.Write(inline[:])
+		} else {
+			binary.Write(buf, le, uint32(values))
+			values += len(f.value) + len(f.value)&1
+		}
+	}
+	binary.Write(buf, le, uint32(0))
+
+	for _, f := range ifd {
+		if len(f.value) > 4 {
+			buf.Write(f.value)
+			if len(f.value)&1 != 0 {
+				buf.WriteByte(0)
+			}
+		}
+	}
+}
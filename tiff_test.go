@@ -0,0 +1,289 @@
+package arw
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+//lzwDecode is a plain TIFF LZW decoder to check lzwEncode against.
+func lzwDecode(data []byte) ([]byte, bool) {
+	var out, prev []byte
+	var table [][]byte
+	reset := func() {
+		table = make([][]byte, lzwFirst)
+		for i := 0; i < 256; i++ {
+			table[i] = []byte{byte(i)}
+		}
+	}
+	reset()
+
+	var bits uint32
+	var nbits uint
+	width := uint(9)
+	for len(data) > 0 || nbits >= width {
+		for nbits < width && len(data) > 0 {
+			bits = bits<<8 | uint32(data[0])
+			data = data[1:]
+			nbits += 8
+		}
+		if nbits < width {
+			break
+		}
+		nbits -= width
+		code := int(bits>>nbits) & (1<<width - 1)
+		bits &= 1<<nbits - 1
+
+		switch {
+		case code == lzwClear:
+			reset()
+			width, prev = 9, nil
+			continue
+		case code == lzwEOI:
+			return out, true
+		}
+		var entry []byte
+		switch {
+		case code < len(table) && table[code] != nil:
+			entry = table[code]
+		case code == len(table) && prev != nil:
+			entry = append(append([]byte{}, prev...), prev[0])
+		default:
+			return out, false
+		}
+		out = append(out, entry...)
+		if prev != nil {
+			table = append(table, append(append([]byte{}, prev...), entry[0]))
+		}
+		prev = entry
+		if len(table)+1 >= 1<<width && width < lzwMaxWidth {
+			width++
+		}
+	}
+	return out, false
+}
+
+func TestLZW(t *testing.T) {
+	//Long runs fill the table quickly, the noise makes it reset a few times.
+	var data []byte
+	for i := 0; i < 1<<16; i++ {
+		data = append(data, byte(i/300), byte(i*7919>>3))
+	}
+	for _, d := range [][]byte{nil, []byte("a"), []byte("TOBEORNOTTOBEORTOBEORNOT"), data} {
+		out, ok := lzwDecode(lzwEncode(d))
+		if !ok || !bytes.Equal(out, d) {
+			t.Errorf("Round trip of %v bytes failed, got %v bytes", len(d), len(out))
+		}
+	}
+}
+
+//readTIFF returns IFD0 of a TIFF written by EncodeTIFF along with its decompressed samples.
+func readTIFF(t *testing.T, data []byte) (EXIFIFD, []byte) {
+	r := bytes.NewReader(data)
+	header, err := ParseHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifd, err := ExtractMetaDataWithOrder(r, header.Order(), int64(header.Offset), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var offsets, counts []uint32
+	var compression uint32
+	for i, fia := range ifd.FIA {
+		switch fia.Tag {
+		case StripOffsets:
+			offsets = longs(ifd.FIAvals[i])
+		case StripByteCounts:
+			counts = longs(ifd.FIAvals[i])
+		case Compression:
+			compression = fia.Offset & 0xffff
+		}
+	}
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		t.Fatalf("Bad strips %v %v", offsets, counts)
+	}
+
+	var samples []byte
+	for i := range offsets {
+		strip := data[offsets[i] : offsets[i]+counts[i]]
+		switch compression {
+		case 8:
+			zr, err := zlib.NewReader(bytes.NewReader(strip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strip, err = ioutil.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		case 5:
+			var ok bool
+			if strip, ok = lzwDecode(strip); !ok {
+				t.Fatal("Corrupt LZW strip", i)
+			}
+		}
+		samples = append(samples, strip...)
+	}
+	return ifd, samples
+}
+
+func TestEncodeTIFF(t *testing.T) {
+	//Tall enough to be split in to several strips.
+	img := NewRGB14(image.Rect(0, 0, 40, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 40; x++ {
+			img.Pix[img.offset(x, y)] = pixel16{R: uint16(x * 400), G: uint16(y * 50), B: uint16(x*y) & 0x3fff}
+		}
+	}
+
+	for _, c := range []TIFFCompression{TIFFUncompressed, TIFFDeflate, TIFFLZW} {
+		for _, eightBit := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := EncodeTIFF(&buf, img, nil, &TIFFOptions{Compression: c, EightBit: eightBit}); err != nil {
+				t.Fatal(err)
+			}
+			ifd, samples := readTIFF(t, buf.Bytes())
+
+			var expected []byte
+			for _, px := range img.Pix {
+				r, g, b, _ := px.RGBA()
+				for _, v := range []uint32{r, g, b} {
+					if eightBit {
+						expected = append(expected, uint8(v>>8))
+					} else {
+						expected = append(expected, uint8(v), uint8(v>>8))
+					}
+				}
+			}
+			if !bytes.Equal(samples, expected) {
+				t.Errorf("%v, 8 bit %v: samples differ from the image", c, eightBit)
+			}
+			for i, fia := range ifd.FIA {
+				if fia.Tag == BitsPerSample {
+					if bits := shorts(ifd.FIAvals[i]); eightBit && bits[0] != 8 || !eightBit && bits[0] != 16 {
+						t.Errorf("%v, 8 bit %v: unexpected bits per sample %v", c, eightBit, bits)
+					}
+				}
+			}
+		}
+	}
+
+	if err := EncodeTIFF(ioutil.Discard, img, nil, &TIFFOptions{Compression: 3}); err != ErrCompression {
+		t.Errorf("Expected %v, got %v", ErrCompression, err)
+	}
+	if err := EncodeTIFF(ioutil.Discard, NewRGB14(image.Rectangle{}), nil, nil); err != ErrEmptyImage {
+		t.Errorf("Expected %v, got %v", ErrEmptyImage, err)
+	}
+}
+
+//writeSizes records the size of every write.
+type writeSizes []int
+
+func (w *writeSizes) Write(p []byte) (int, error) {
+	*w = append(*w, len(p))
+	return len(p), nil
+}
+
+func TestEncodeTIFFStreams(t *testing.T) {
+	img := NewRGB14(image.Rect(0, 0, 100, 200))
+	for _, c := range []TIFFCompression{TIFFUncompressed, TIFFDeflate} {
+		var writes writeSizes
+		if err := EncodeTIFF(&writes, img, nil, &TIFFOptions{Compression: c}); err != nil {
+			t.Fatal(err)
+		}
+		//The IFDs come first and every strip is written on its own, the file is never held in one piece.
+		strips := (200*100*6 + tiffStripSize - 1) / tiffStripSize
+		if len(writes) < strips {
+			t.Errorf("%v: expected at least %v writes, got %v", c, strips, writes)
+		}
+	}
+}
+
+func TestEncodeTIFFExif(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	file.Make, file.Model = "SONY", "ILCE-7RM3"
+	file.Exif = arwtest.Exif{
+		ExposureTime:     [2]uint32{1, 250},
+		LensModel:        "FE 35mm F1.8",
+		DateTimeOriginal: "2018:09:07 14:03:21",
+	}
+	file.GPS = []arwtest.Tag{
+		arwtest.Byte(0, 2, 3, 0, 0),
+		arwtest.ASCII(1, "N"),
+		arwtest.Rational(2, 52, 1, 22, 1, 1234, 100),
+	}
+	icc := []byte("not really an ICC profile")
+
+	img, err := Decode(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeTIFF(&buf, img, bytes.NewReader(file.Bytes()), &TIFFOptions{ICCProfile: icc}); err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.NewReader(buf.Bytes())
+	ifd0, _ := readTIFF(t, buf.Bytes())
+
+	var exif, gps EXIFIFD
+	for i, fia := range ifd0.FIA {
+		switch fia.Tag {
+		case Make, Model:
+			if v := ascii(ifd0.FIAvals[i]); v != file.Make && v != file.Model {
+				t.Errorf("Unexpected %v %q", fia.Tag, v)
+			}
+		case InterColorProfile:
+			if !bytes.Equal(*ifd0.FIAvals[i].ascii, icc) {
+				t.Error("Expected the ICC profile, got:", *ifd0.FIAvals[i].ascii)
+			}
+			icc = nil
+		case ExifTag:
+			if exif, err = ExtractMetaDataWithOrder(out, binary.LittleEndian, int64(fia.Offset), 0); err != nil {
+				t.Fatal(err)
+			}
+		case GPSTag:
+			if gps, err = ExtractMetaDataWithOrder(out, binary.LittleEndian, int64(fia.Offset), 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if icc != nil {
+		t.Error("Expected an ICC profile")
+	}
+
+	found := make(map[IFDtag]interface{})
+	for i, fia := range exif.FIA {
+		switch fia.Tag {
+		case ExposureTime:
+			found[fia.Tag] = time.Duration(float64(rational(exif.FIAvals[i])) * float64(time.Second))
+		case LensModel, DateTimeOriginal:
+			found[fia.Tag] = ascii(exif.FIAvals[i])
+		}
+	}
+	expected := map[IFDtag]interface{}{
+		ExposureTime:     4 * time.Millisecond,
+		LensModel:        file.Exif.LensModel,
+		DateTimeOriginal: file.Exif.DateTimeOriginal,
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected Exif %v, got %v", expected, found)
+	}
+
+	if len(gps.FIA) != len(file.GPS) {
+		t.Fatalf("Expected %v GPS fields, got %v", len(file.GPS), len(gps.FIA))
+	}
+	if ref := ascii(gps.FIAvals[1]); ref != "N" {
+		t.Error("Expected latitude reference N, got:", ref)
+	}
+	if lat := *gps.FIAvals[2].rat; !reflect.DeepEqual(lat, []float32{52, 22, 12.34}) {
+		t.Error("Unexpected latitude:", lat)
+	}
+}
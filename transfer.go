@@ -0,0 +1,59 @@
+package arw
+
+import "math"
+
+//TransferFunction is the encoding applied to the linear values of the developed image.
+//go:generate stringer -type=TransferFunction
+type TransferFunction uint8
+
+const (
+	//TransferSRGB is the piecewise sRGB curve from IEC 61966-2-1, the default.
+	TransferSRGB TransferFunction = iota
+	//TransferRec709 is the ITU-R BT.709 camera curve.
+	TransferRec709
+	//TransferGamma22 is a pure power curve with an exponent of 1/2.2, as used by Adobe RGB.
+	TransferGamma22
+	//TransferLinear leaves the values linear.
+	TransferLinear
+)
+
+//encode applies the transfer function to a linear value between 0 and 1.
+func (f TransferFunction) encode(x float64) float64 {
+	switch f {
+	case TransferSRGB:
+		if x <= 0.0031308 {
+			return 12.92 * x
+		}
+		return 1.055*math.Pow(x, 1/2.4) - 0.055
+	case TransferRec709:
+		if x < 0.018 {
+			return 4.5 * x
+		}
+		return 1.099*math.Pow(x, 0.45) - 0.099
+	case TransferGamma22:
+		return math.Pow(x, 1/2.2)
+	}
+	return x
+}
+
+//transferLUT holds a transfer function sampled at every 14 bit value.
+type transferLUT [0x4000]uint16
+
+func newTransferLUT(f TransferFunction) *transferLUT {
+	var lut transferLUT
+	for i := range lut {
+		lut[i] = uint16(f.encode(float64(i)/0x3fff)*0x3fff + 0.5)
+	}
+	return &lut
+}
+
+//at encodes a linear value between 0 and 1, values outside of that range are clipped.
+func (lut *transferLUT) at(x float64) uint16 {
+	switch {
+	case x <= 0:
+		return lut[0]
+	case x >= 1:
+		return lut[len(lut)-1]
+	}
+	return lut[int(x*0x3fff+0.5)]
+}
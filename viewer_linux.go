@@ -137,17 +137,9 @@ func display(img *image.RGBA, fileName, lensName string, focalLength float32, ap
 	buf := backingBuffer.GetPixels()
 	copy(buf, img.Pix)
 
+	//The image is expected to be upright already, decode it with Options.Upright.
 	width, _ := gtkView.GetPreferredWidth()
 	height, _ := gtkView.GetPreferredHeight()
-	rotation := gdk.PixbufRotation(gdk.PIXBUF_ROTATE_COUNTERCLOCKWISE)
-	if rotation == gdk.PIXBUF_ROTATE_COUNTERCLOCKWISE {
-		width, height = height, width
-	}
-
-	backingBuffer, err = backingBuffer.RotateSimple(rotation)
-	if err != nil {
-		panic(err)
-	}
 
 	frontbuffer, err := gdk.PixbufNew(gdk.COLORSPACE_RGB, true, 8, backingBuffer.GetWidth(), backingBuffer.GetHeight())
 
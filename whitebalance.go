@@ -0,0 +1,258 @@
+package arw
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+//WhiteBalancePreset is one of the white balance settings the camera stores next to the one it was shot with.
+//go:generate stringer -type=WhiteBalancePreset
+type WhiteBalancePreset uint8
+
+const (
+	WhiteBalanceAsShot WhiteBalancePreset = iota
+	WhiteBalanceDaylight
+	WhiteBalanceCloudy
+	WhiteBalanceShade
+	WhiteBalanceTungsten
+	WhiteBalanceFlash
+	WhiteBalanceFluorescent
+	WhiteBalanceFluorescentP1
+	WhiteBalanceFluorescentP2
+	WhiteBalanceFluorescentM1
+	WhiteBalance2500K
+	WhiteBalance3200K
+	WhiteBalance4500K
+	WhiteBalance6000K
+	WhiteBalance8500K
+)
+
+//ErrNoWhiteBalance is returned when the requested white balance can't be made from the presets in the file.
+var ErrNoWhiteBalance = errors.New("white balance preset not found in file")
+
+//presetTags maps the tags holding the presets to the preset, newer bodies use the tags ending in 2.
+var presetTags = map[IFDtag]WhiteBalancePreset{
+	WB_RGBLevelsDaylight:      WhiteBalanceDaylight,
+	WB_RGBLevelsCloudy:        WhiteBalanceCloudy,
+	WB_RGBLevelsTungsten:      WhiteBalanceTungsten,
+	WB_RGBLevelsFlash:         WhiteBalanceFlash,
+	WB_RGBLevels4500K:         WhiteBalance4500K,
+	WB_RGBLevelsFluorescent:   WhiteBalanceFluorescent,
+	WB_RGBLevelsDaylight2:     WhiteBalanceDaylight,
+	WB_RGBLevelsCloudy2:       WhiteBalanceCloudy,
+	WB_RGBLevelsTungsten2:     WhiteBalanceTungsten,
+	WB_RGBLevelsFlash2:        WhiteBalanceFlash,
+	WB_RGBLevels4500K2:        WhiteBalance4500K,
+	WB_RGBLevelsShade2:        WhiteBalanceShade,
+	WB_RGBLevelsFluorescent2:  WhiteBalanceFluorescent,
+	WB_RGBLevelsFluorescentP1: WhiteBalanceFluorescentP1,
+	WB_RGBLevelsFluorescentP2: WhiteBalanceFluorescentP2,
+	WB_RGBLevelsFluorescentM1: WhiteBalanceFluorescentM1,
+	WB_RGBLevels8500K:         WhiteBalance8500K,
+	WB_RGBLevels6000K:         WhiteBalance6000K,
+	WB_RGBLevels3200K:         WhiteBalance3200K,
+	WB_RGBLevels2500K:         WhiteBalance2500K,
+}
+
+//presetTemperatures holds the colour temperature in Kelvin of the presets used to interpolate arbitrary temperatures.
+//Flash and the fluorescent presets are left out since they aren't on the black body curve.
+var presetTemperatures = map[WhiteBalancePreset]float64{
+	WhiteBalance2500K:    2500,
+	WhiteBalance3200K:    3200,
+	WhiteBalanceTungsten: 3200,
+	WhiteBalance4500K:    4500,
+	WhiteBalanceDaylight: 5500,
+	WhiteBalance6000K:    6000,
+	WhiteBalanceCloudy:   6500,
+	WhiteBalanceShade:    7500,
+	WhiteBalance8500K:    8500,
+}
+
+//presetLevels turns the values of a preset tag in to RGGB levels, some bodies store R, G, B and others R, G, G, B.
+func presetLevels(values []uint16) ([4]int16, bool) {
+	switch len(values) {
+	case 3:
+		return [4]int16{int16(values[0]), int16(values[1]), int16(values[1]), int16(values[2])}, true
+	case 4:
+		return [4]int16{int16(values[0]), int16(values[1]), int16(values[2]), int16(values[3])}, true
+	}
+	return [4]int16{}, false
+}
+
+//whiteBalance returns the RGGB levels requested by opts in the same form as the as shot levels.
+func (rw rawDetails) whiteBalance(opts Options) ([4]int16, error) {
+	if opts.Temperature > 0 {
+		return rw.temperatureBalance(opts.Temperature, opts.Tint)
+	}
+	if opts.WhiteBalance == WhiteBalanceAsShot {
+		return rw.WhiteBalance, nil
+	}
+	levels, ok := rw.wbPresets[opts.WhiteBalance]
+	if !ok {
+		return levels, ErrNoWhiteBalance
+	}
+	return levels, nil
+}
+
+//temperatureBalance interpolates the levels for a colour temperature between the presets in the file.
+//Red and blue relative to green are interpolated linearly in mired, the reciprocal temperature scale in which colour differences are spread most evenly.
+//Temperatures outside of the presets take the levels of the closest one.
+func (rw rawDetails) temperatureBalance(kelvin, tint float64) ([4]int16, error) {
+	type point struct {
+		mired, red, blue float64
+	}
+	var points []point
+	for preset, temperature := range presetTemperatures {
+		levels, ok := rw.wbPresets[preset]
+		if !ok || levels[1] <= 0 {
+			continue
+		}
+		green := float64(levels[1])
+		points = append(points, point{1e6 / temperature, float64(levels[0]) / green, float64(levels[3]) / green})
+	}
+	if len(points) < 2 {
+		return [4]int16{}, ErrNoWhiteBalance
+	}
+	//Map iteration order is random and presets may share a temperature, sorting keeps the result stable.
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].mired != points[j].mired {
+			return points[i].mired < points[j].mired
+		}
+		return points[i].red < points[j].red
+	})
+
+	mired := 1e6 / kelvin
+	red, blue := points[0].red, points[0].blue
+	for i, p := range points[1:] {
+		q := points[i]
+		if mired >= p.mired {
+			red, blue = p.red, p.blue
+			continue
+		}
+		if mired > q.mired {
+			t := (mired - q.mired) / (p.mired - q.mired)
+			red, blue = q.red+t*(p.red-q.red), q.blue+t*(p.blue-q.blue)
+		}
+		break
+	}
+
+	//Tint moves green against magenta, +100 halves the green level which adds magenta.
+	green := 1024 * math.Exp2(-tint/100)
+	return [4]int16{level(red * 1024), level(green), level(green), level(blue * 1024)}, nil
+}
+
+func level(v float64) int16 {
+	switch {
+	case v < 1:
+		return 1
+	case v > math.MaxInt16:
+		return math.MaxInt16
+	}
+	return int16(v + 0.5)
+}
+
+//WhiteBalanceEstimator estimates the white balance of a scene from the sensor mosaic, before it is balanced or demosaiced.
+//The result is RGGB levels in the same form as the ones stored in the file.
+type WhiteBalanceEstimator interface {
+	EstimateWhiteBalance(b *Bayer) [4]int16
+}
+
+//GrayWorld assumes the scene averages out to grey.
+type GrayWorld struct{}
+
+//WhitePatch assumes the brightest value of every channel belongs to something white.
+type WhitePatch struct{}
+
+//PercentileWhite is a more robust WhitePatch, it takes the value of every channel at Percentile instead of its maximum
+//so a few hot or specular photosites don't decide the result. Percentile is between 0 and 1, 0.98 is used when it is unset.
+type PercentileWhite struct {
+	Percentile float64
+}
+
+func (GrayWorld) EstimateWhiteBalance(b *Bayer) [4]int16 {
+	var sum [3]float64
+	var count [3]int
+	b.eachUnclipped(func(c CFAColor, v uint16) {
+		sum[c] += float64(v)
+		count[c]++
+	})
+	var mean [3]float64
+	for c := range mean {
+		if count[c] > 0 {
+			mean[c] = sum[c] / float64(count[c])
+		}
+	}
+	return levelsFrom(mean)
+}
+
+func (WhitePatch) EstimateWhiteBalance(b *Bayer) [4]int16 {
+	var max [3]float64
+	b.eachUnclipped(func(c CFAColor, v uint16) {
+		if float64(v) > max[c] {
+			max[c] = float64(v)
+		}
+	})
+	return levelsFrom(max)
+}
+
+func (p PercentileWhite) EstimateWhiteBalance(b *Bayer) [4]int16 {
+	percentile := p.Percentile
+	if percentile <= 0 || percentile > 1 {
+		percentile = 0.98
+	}
+
+	var white uint16
+	for _, v := range b.WhiteLevel {
+		if v > white {
+			white = v
+		}
+	}
+	var histogram [3][]int
+	var count [3]int
+	for c := range histogram {
+		histogram[c] = make([]int, int(white)+1)
+	}
+	b.eachUnclipped(func(c CFAColor, v uint16) {
+		histogram[c][v]++
+		count[c]++
+	})
+
+	var levels [3]float64
+	for c, h := range histogram {
+		rank := int(math.Ceil(percentile * float64(count[c])))
+		for v, n := range h {
+			rank -= n
+			if rank <= 0 {
+				levels[c] = float64(v)
+				break
+			}
+		}
+	}
+	return levelsFrom(levels)
+}
+
+//eachUnclipped calls f with the colour and black subtracted value of every photosite in the active area.
+//Photosites close to the white level are skipped since clipping has already thrown away their colour.
+func (b *Bayer) eachUnclipped(f func(c CFAColor, v uint16)) {
+	r := b.ActiveArea.Intersect(b.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			v, black, white := b.Pix[b.PixOffset(x, y)], b.BlackAt(x, y), b.WhiteAt(x, y)
+			if v >= white-white/32 || v <= black {
+				continue
+			}
+			f(b.ColorAt(x, y), v-black)
+		}
+	}
+}
+
+//levelsFrom returns the levels which make the estimated white of every channel equal to green.
+//Without an estimate for every channel the image is left unbalanced.
+func levelsFrom(white [3]float64) [4]int16 {
+	if white[CFARed] <= 0 || white[CFAGreen] <= 0 || white[CFABlue] <= 0 {
+		return [4]int16{1024, 1024, 1024, 1024}
+	}
+	red, blue := level(1024*white[CFAGreen]/white[CFARed]), level(1024*white[CFAGreen]/white[CFABlue])
+	return [4]int16{red, 1024, 1024, blue}
+}
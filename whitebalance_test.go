@@ -0,0 +1,182 @@
+package arw
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/Soreil/arw/internal/arwtest"
+)
+
+//presetFile carries a tungsten, daylight and 8500K preset, the first as R, G, G, B and the others as R, G, B.
+func presetFile() arwtest.File {
+	file := syntheticFile(arwtest.Raw14)
+	file.RawTags = []arwtest.Tag{
+		arwtest.SShort(uint16(WB_RGBLevelsTungsten), 1200, 1024, 1024, 2600),
+		arwtest.SShort(uint16(WB_RGBLevelsDaylight2), 2000, 1024, 1600),
+		arwtest.SShort(uint16(WB_RGBLevels8500K), 2600, 1024, 1200),
+	}
+	return file
+}
+
+func TestWhiteBalancePresets(t *testing.T) {
+	file := presetFile()
+	rw, err := extractDetails(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[WhiteBalancePreset][4]int16{
+		WhiteBalanceAsShot:   file.WhiteBalance,
+		WhiteBalanceTungsten: {1200, 1024, 1024, 2600},
+		WhiteBalanceDaylight: {2000, 1024, 1024, 1600},
+		WhiteBalance8500K:    {2600, 1024, 1024, 1200},
+	}
+	for preset, levels := range expected {
+		wb, err := rw.whiteBalance(Options{WhiteBalance: preset})
+		if err != nil {
+			t.Fatal(preset, err)
+		}
+		if wb != levels {
+			t.Errorf("%v: expected %v, got %v", preset, levels, wb)
+		}
+	}
+
+	if _, err := rw.whiteBalance(Options{WhiteBalance: WhiteBalanceShade}); err != ErrNoWhiteBalance {
+		t.Errorf("Expected %v for a missing preset, got %v", ErrNoWhiteBalance, err)
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{WhiteBalance: WhiteBalanceFlash}); err != ErrNoWhiteBalance {
+		t.Errorf("Expected %v decoding with a missing preset, got %v", ErrNoWhiteBalance, err)
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{WhiteBalance: WhiteBalanceDaylight}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWhiteBalanceTemperature(t *testing.T) {
+	file := presetFile()
+	rw, err := extractDetails(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		kelvin, tint float64
+		levels       [4]int16
+	}{
+		//The presets themselves and the ends of the range.
+		{3200, 0, [4]int16{1200, 1024, 1024, 2600}},
+		{5500, 0, [4]int16{2000, 1024, 1024, 1600}},
+		{8500, 0, [4]int16{2600, 1024, 1024, 1200}},
+		{2000, 0, [4]int16{1200, 1024, 1024, 2600}},
+		{12000, 0, [4]int16{2600, 1024, 1024, 1200}},
+		//Half way between 3200K and 5500K in mired.
+		{1e6 / ((1e6/3200 + 1e6/5500) / 2), 0, [4]int16{1600, 1024, 1024, 2100}},
+		{5500, 100, [4]int16{2000, 512, 512, 1600}},
+		{5500, -100, [4]int16{2000, 2048, 2048, 1600}},
+	}
+	for _, e := range expected {
+		wb, err := rw.whiteBalance(Options{Temperature: e.kelvin, Tint: e.tint})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wb != e.levels {
+			t.Errorf("%vK, tint %v: expected %v, got %v", e.kelvin, e.tint, e.levels, wb)
+		}
+	}
+
+	//A single preset isn't enough to interpolate from.
+	file.RawTags = file.RawTags[:1]
+	if _, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{Temperature: 4000}); err != ErrNoWhiteBalance {
+		t.Errorf("Expected %v, got %v", ErrNoWhiteBalance, err)
+	}
+}
+
+//castMosaic is a grey scene under a light which gives red half and blue a quarter of the green response.
+//The left half is lit twice as bright as the right, every colour has a clipped photosite and hot red photosites can be added.
+func castMosaic(hot ...image.Point) *Bayer {
+	const width, height = 32, 16
+	b := &Bayer{
+		Pix:        make([]uint16, width*height),
+		Stride:     width,
+		Rect:       image.Rect(0, 0, width, height),
+		Pattern:    [4]CFAColor{CFAGreen, CFARed, CFABlue, CFAGreen},
+		PatternDim: [2]int{2, 2},
+		BlackLevel: [4]uint16{128, 128, 128, 128},
+		WhiteLevel: [4]uint16{0x3fff, 0x3fff, 0x3fff, 0x3fff},
+		ActiveArea: image.Rect(0, 0, width, height),
+	}
+	response := [3]uint16{1000, 2000, 500}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := response[b.ColorAt(x, y)]
+			if x >= width/2 {
+				v /= 2
+			}
+			b.Pix[b.PixOffset(x, y)] = 128 + v
+		}
+	}
+	for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		b.Pix[b.PixOffset(p.X, p.Y)] = 0x3fff
+	}
+	for _, p := range hot {
+		b.Pix[b.PixOffset(p.X, p.Y)] = 128 + 8000
+	}
+	return b
+}
+
+func TestEstimateWhiteBalance(t *testing.T) {
+	expected := [4]int16{2048, 1024, 1024, 4096}
+
+	for name, e := range map[string]WhiteBalanceEstimator{
+		"gray world":  GrayWorld{},
+		"white patch": WhitePatch{},
+		"percentile":  PercentileWhite{},
+	} {
+		if wb := e.EstimateWhiteBalance(castMosaic()); wb != expected {
+			t.Errorf("%v: expected %v, got %v", name, expected, wb)
+		}
+	}
+
+	//A few hot red photosites fool the white patch but not the percentile.
+	hot := castMosaic(image.Point{1, 2}, image.Point{3, 4}, image.Point{5, 6})
+	if wb := (WhitePatch{}).EstimateWhiteBalance(hot); wb[0] != 256 {
+		t.Error("Expected the hot photosites to decide the white patch red level, got:", wb)
+	}
+	if wb := (PercentileWhite{Percentile: 0.95}).EstimateWhiteBalance(hot); wb != expected {
+		t.Errorf("Percentile: expected %v, got %v", expected, wb)
+	}
+
+	black := castMosaic()
+	for i := range black.Pix {
+		black.Pix[i] = 0
+	}
+	if wb := (GrayWorld{}).EstimateWhiteBalance(black); wb != [4]int16{1024, 1024, 1024, 1024} {
+		t.Error("Expected a black frame to be left unbalanced, got:", wb)
+	}
+}
+
+func TestDecodeAutoWhiteBalance(t *testing.T) {
+	file := syntheticFile(arwtest.Raw14)
+	file.CFAPattern = [4]uint8{0, 1, 1, 2}
+	response := [3]uint16{1000, 2000, 500}
+	for y := 0; y < file.Height; y++ {
+		for x := 0; x < file.Width; x++ {
+			file.Pixels[y*file.Width+x] = 128 + response[file.CFAPattern[y%2*2+x%2]]
+		}
+	}
+
+	img, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{AutoWhiteBalance: GrayWorld{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, px := range img.(*RGB14).Pix {
+		if px.R != px.G || px.G != px.B {
+			t.Fatalf("Pixel %v: expected grey, got %v", i, px)
+		}
+	}
+	//The file has no presets, the estimator is used instead of failing on the missing one.
+	if _, err := DecodeWithOptions(bytes.NewReader(file.Bytes()), &Options{WhiteBalance: WhiteBalanceShade, AutoWhiteBalance: GrayWorld{}}); err != nil {
+		t.Error("Expected the estimator to override a missing preset, got:", err)
+	}
+}